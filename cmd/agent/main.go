@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"awesomeProject6/internal/config"
+	"awesomeProject6/internal/setup"
+	"awesomeProject6/pkg/agent"
+	"awesomeProject6/pkg/kafka"
+	"awesomeProject6/pkg/parser"
+)
+
+func main() {
+	logger := logrus.New()
+
+	cfg, err := config.LoadConfig("config.yaml")
+	if err != nil {
+		logger.Fatalf("Failed to load config: %v", err)
+	}
+
+	host := cfg.Agent.Host
+	if host == "" {
+		if host, err = os.Hostname(); err != nil {
+			logger.Fatalf("Failed to determine hostname: %v", err)
+		}
+	}
+
+	pollInterval, err := setup.ParseOptionalDuration(cfg.Agent.PollInterval)
+	if err != nil {
+		logger.Fatalf("Invalid poll_interval: %v", err)
+	}
+
+	parsers, err := parser.NewRegistry(parser.FormatText, parser.Defaults{Host: host})
+	if err != nil {
+		logger.Fatalf("Failed to create parsers: %v", err)
+	}
+
+	producer, err := kafka.NewProducer(cfg.Kafka.Brokers, cfg.Kafka.Topic, kafka.ProducerOptions{
+		Security: setup.KafkaSecurity(cfg),
+	})
+	if err != nil {
+		logger.Fatalf("Failed to create Kafka producer: %v", err)
+	}
+	defer producer.Close()
+
+	inputs := make([]agent.Input, 0, len(cfg.Agent.Inputs))
+	for _, input := range cfg.Agent.Inputs {
+		inputs = append(inputs, agent.Input{
+			Paths:   input.Paths,
+			Format:  input.Format,
+			Service: input.Service,
+		})
+	}
+
+	tailer, err := agent.NewTailer(agent.Config{
+		Inputs:       inputs,
+		RegistryPath: cfg.Agent.RegistryPath,
+		PollInterval: pollInterval,
+		BatchSize:    cfg.Agent.BatchSize,
+		MaxLineBytes: cfg.Agent.MaxLineBytes,
+	}, producer, parsers)
+	if err != nil {
+		logger.Fatalf("Failed to create tailer: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tailer.Run(ctx)
+	}()
+
+	logger.Infof("File agent shipping to %s", cfg.Kafka.Topic)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+
+	logger.Info("Shutting down file agent...")
+	cancel()
+	<-done
+}
@@ -3,15 +3,22 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 	"awesomeProject6/internal/config"
 	"awesomeProject6/internal/models"
+	"awesomeProject6/internal/setup"
+	"awesomeProject6/pkg/api"
+	"awesomeProject6/pkg/elasticsearch"
+	"awesomeProject6/pkg/kafka"
 	"awesomeProject6/pkg/prometheus"
 	"awesomeProject6/pkg/rules"
 )
@@ -25,16 +32,32 @@ func main() {
 	}
 
 	collector := prometheus.NewMetricCollector()
+	if cfg.Metrics.RetentionDays > 0 {
+		collector.SetRetention(time.Duration(cfg.Metrics.RetentionDays) * 24 * time.Hour)
+	}
 	aggregator := prometheus.NewAggregator(collector)
 	alertChan := make(chan models.Alert, 100)
 
-	engine := rules.NewEngine(aggregator, alertChan)
-
 	alertRules, err := loadAlertRules(cfg.Alerting.RulesPath)
 	if err != nil {
 		logger.Fatalf("Failed to load alert rules: %v", err)
 	}
 
+	var esClient *elasticsearch.Client
+	if hasLogRules(alertRules) {
+		esClient, err = elasticsearch.NewClient(elasticsearch.ClientConfig{
+			URLs:     cfg.Elasticsearch.URLs,
+			Username: cfg.Elasticsearch.Username,
+			Password: cfg.Elasticsearch.Password,
+			Index:    cfg.Elasticsearch.Index,
+			Prefixes: cfg.SubscriptionIndices(),
+		})
+		if err != nil {
+			logger.Fatalf("Failed to create Elasticsearch client: %v", err)
+		}
+	}
+
+	engine := rules.NewEngine(aggregator, esClient, alertChan)
 	engine.LoadRules(alertRules)
 
 	interval, err := time.ParseDuration(cfg.Alerting.CheckInterval)
@@ -42,9 +65,73 @@ func main() {
 		logger.Fatalf("Invalid check interval: %v", err)
 	}
 
+	historyPath := cfg.Alerting.HistoryPath
+	if historyPath == "" {
+		historyPath = "alert_history.jsonl"
+	}
+
+	historyOptions := rules.HistoryOptions{
+		MaxAge:   7 * 24 * time.Hour,
+		MaxBytes: 100 * 1024 * 1024,
+	}
+	if cfg.Alerting.HistoryMaxAge != "" {
+		historyOptions.MaxAge, err = time.ParseDuration(cfg.Alerting.HistoryMaxAge)
+		if err != nil {
+			logger.Fatalf("Invalid alert history max age: %v", err)
+		}
+	}
+	if cfg.Alerting.HistoryMaxMB > 0 {
+		historyOptions.MaxBytes = int64(cfg.Alerting.HistoryMaxMB) * 1024 * 1024
+	}
+
+	history, err := rules.NewHistory(historyPath, historyOptions)
+	if err != nil {
+		logger.Fatalf("Failed to open alert history: %v", err)
+	}
+	defer history.Close()
+
+	var producer *kafka.Producer
+	if cfg.Alerting.Publish.Enabled {
+		producer, err = kafka.NewProducer(
+			cfg.Kafka.Brokers,
+			cfg.Alerting.Publish.Topic,
+			kafka.ProducerOptions{
+				RequiredAcks: cfg.Alerting.Publish.RequiredAcks,
+				Idempotent:   cfg.Alerting.Publish.Idempotent,
+				MaxRetries:   cfg.Alerting.Publish.MaxRetries,
+				Security:     setup.KafkaSecurity(cfg),
+			},
+		)
+		if err != nil {
+			logger.Fatalf("Failed to create Kafka producer: %v", err)
+		}
+		defer producer.Close()
+	}
+
+	port := cfg.Alerting.Port
+	if port == 0 {
+		port = 9093
+	}
+
+	router := mux.NewRouter()
+	handlers := api.NewAlertHandlers(engine, history)
+	handlers.SetupRoutes(router)
+
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%d", port),
+		Handler:      router,
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
-	go engine.Start(ctx, interval)
+	engineDone := make(chan struct{})
+	go func() {
+		defer close(engineDone)
+		engine.Start(ctx, interval)
+	}()
 
 	go func() {
 		for alert := range alertChan {
@@ -52,6 +139,53 @@ func main() {
 		}
 	}()
 
+	// Publishing retries for as long as Kafka is unavailable, so it runs in
+	// its own goroutine behind a buffer and never holds up the history.
+	var publishChan chan models.AlertTransition
+	publishDone := make(chan struct{})
+	if producer != nil {
+		publishChan = make(chan models.AlertTransition, 1000)
+		go func() {
+			defer close(publishDone)
+			for transition := range publishChan {
+				if err := producer.Publish(transition.Fingerprint, transition); err != nil {
+					logger.Errorf("Failed to publish alert transition: %v", err)
+				}
+			}
+		}()
+	} else {
+		close(publishDone)
+	}
+
+	// The engine closes its transitions channel once it stops, so this loop
+	// ends after recording the last transition.
+	transitionsDone := make(chan struct{})
+	go func() {
+		defer close(transitionsDone)
+		for transition := range engine.Transitions() {
+			if err := history.Record(transition); err != nil {
+				logger.Errorf("Failed to record alert transition: %v", err)
+			}
+			if publishChan != nil {
+				select {
+				case publishChan <- transition:
+				default:
+					logger.Warnf("Publish buffer full, dropping alert transition for %s", transition.Fingerprint)
+				}
+			}
+		}
+		if publishChan != nil {
+			close(publishChan)
+		}
+	}()
+
+	go func() {
+		logger.Infof("Starting alerting API on port %d", port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Fatalf("Server failed: %v", err)
+		}
+	}()
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
@@ -60,7 +194,25 @@ func main() {
 
 	logger.Info("Shutting down alerting system...")
 	cancel()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer shutdownCancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Errorf("Server shutdown error: %v", err)
+	}
+
+	// Wait for the engine before closing the channels it sends on, and for
+	// every transition to be recorded before the history is closed.
+	<-engineDone
 	close(alertChan)
+	<-transitionsDone
+
+	select {
+	case <-publishDone:
+	case <-shutdownCtx.Done():
+		logger.Warn("Timed out publishing the remaining alert transitions")
+	}
 
 	logger.Info("Alerting system shutdown complete")
 }
@@ -80,11 +232,28 @@ func loadAlertRules(path string) ([]models.AlertRule, error) {
 	return rules, nil
 }
 
+func hasLogRules(alertRules []models.AlertRule) bool {
+	for _, rule := range alertRules {
+		if rule.Type == models.RuleTypeLog {
+			return true
+		}
+	}
+	return false
+}
+
 func handleAlert(alert models.Alert, logger *logrus.Logger) {
-	logger.WithFields(logrus.Fields{
+	fields := logrus.Fields{
 		"rule":      alert.Rule.Name,
 		"value":     alert.Value,
 		"threshold": alert.Rule.Threshold,
 		"status":    alert.Status,
-	}).Warn("Alert triggered")
-}
\ No newline at end of file
+		"labels":    alert.Labels,
+	}
+	if alert.Baseline != nil {
+		fields["baseline"] = alert.Baseline.Mean
+		fields["stddev"] = alert.Baseline.StdDev
+		fields["deviation"] = alert.Baseline.Deviation
+	}
+
+	logger.WithFields(fields).Warn("Alert triggered")
+}
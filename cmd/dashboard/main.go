@@ -25,12 +25,12 @@ func main() {
 		logger.Fatalf("Failed to load config: %v", err)
 	}
 
-	esClient, err := elasticsearch.NewClient(
-		cfg.Elasticsearch.URLs,
-		cfg.Elasticsearch.Username,
-		cfg.Elasticsearch.Password,
-		cfg.Elasticsearch.Index,
-	)
+	esClient, err := elasticsearch.NewClient(elasticsearch.ClientConfig{
+		URLs:     cfg.Elasticsearch.URLs,
+		Username: cfg.Elasticsearch.Username,
+		Password: cfg.Elasticsearch.Password,
+		Index:    cfg.Elasticsearch.Index,
+	})
 	if err != nil {
 		logger.Fatalf("Failed to create Elasticsearch client: %v", err)
 	}
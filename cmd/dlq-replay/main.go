@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/sirupsen/logrus"
+	"awesomeProject6/internal/config"
+	"awesomeProject6/internal/setup"
+	"awesomeProject6/pkg/kafka"
+)
+
+func main() {
+	logger := logrus.New()
+
+	target := flag.String("target", "", "topic to replay into (default: each message's source topic)")
+	limit := flag.Int("limit", 0, "maximum number of messages to replay (0 for all)")
+	groupID := flag.String("group", "log-dlq-replay", "consumer group used to track replay progress")
+	flag.Parse()
+
+	cfg, err := config.LoadConfig("config.yaml")
+	if err != nil {
+		logger.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Kafka.DeadLetterTopic == "" {
+		logger.Fatal("No dead_letter_topic configured")
+	}
+
+	producer, err := kafka.NewProducer(cfg.Kafka.Brokers, cfg.Kafka.Topic, kafka.ProducerOptions{
+		Security: setup.KafkaSecurity(cfg),
+	})
+	if err != nil {
+		logger.Fatalf("Failed to create Kafka producer: %v", err)
+	}
+	defer producer.Close()
+
+	replayer, err := kafka.NewDeadLetterReplayer(cfg.Kafka.Brokers, setup.KafkaSecurity(cfg), *groupID, cfg.Kafka.DeadLetterTopic, producer)
+	if err != nil {
+		logger.Fatalf("Failed to create dead-letter replayer: %v", err)
+	}
+	defer replayer.Close()
+
+	replayed, err := replayer.Replay(*target, *limit)
+	if err != nil {
+		logger.Errorf("Replay stopped after %d messages: %v", replayed, err)
+		return
+	}
+
+	logger.Infof("Replayed %d messages from %s", replayed, cfg.Kafka.DeadLetterTopic)
+}
@@ -2,17 +2,27 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"awesomeProject6/internal/config"
-	"awesomeProject6/internal/models"
+	"awesomeProject6/internal/setup"
+	"awesomeProject6/pkg/api"
 	"awesomeProject6/pkg/elasticsearch"
+	"awesomeProject6/pkg/ingestion"
 	"awesomeProject6/pkg/kafka"
+	"awesomeProject6/pkg/parser"
+	"awesomeProject6/pkg/processor"
+	"awesomeProject6/pkg/syslog"
 )
 
 func main() {
@@ -23,24 +33,115 @@ func main() {
 		logger.Fatalf("Failed to load config: %v", err)
 	}
 
-	esClient, err := elasticsearch.NewClient(
-		cfg.Elasticsearch.URLs,
-		cfg.Elasticsearch.Username,
-		cfg.Elasticsearch.Password,
-		cfg.Elasticsearch.Index,
-	)
+	maxFuture, err := setup.ParseOptionalDuration(cfg.Elasticsearch.MaxFuture)
+	if err != nil {
+		logger.Fatalf("Invalid max_future: %v", err)
+	}
+
+	maxPast, err := setup.ParseOptionalDuration(cfg.Elasticsearch.MaxPast)
+	if err != nil {
+		logger.Fatalf("Invalid max_past: %v", err)
+	}
+
+	esClient, err := elasticsearch.NewClient(elasticsearch.ClientConfig{
+		URLs:         cfg.Elasticsearch.URLs,
+		Username:     cfg.Elasticsearch.Username,
+		Password:     cfg.Elasticsearch.Password,
+		Index:        cfg.Elasticsearch.Index,
+		IndexPattern: cfg.Elasticsearch.IndexPattern,
+		Timezone:     cfg.Elasticsearch.Timezone,
+		MaxFuture:    maxFuture,
+		MaxPast:      maxPast,
+		Prefixes:     cfg.SubscriptionIndices(),
+	})
 	if err != nil {
 		logger.Fatalf("Failed to create Elasticsearch client: %v", err)
 	}
 
-	logChan := make(chan models.LogEntry, 1000)
+	subscriptions := setup.KafkaSubscriptions(cfg)
+
+	err = esClient.InstallIndexTemplate(context.Background(),
+		elasticsearch.IndexSettings{
+			Shards:   cfg.Elasticsearch.Shards,
+			Replicas: cfg.Elasticsearch.Replicas,
+		},
+		elasticsearch.LifecyclePolicy{
+			Enabled:     cfg.Elasticsearch.Lifecycle.Enabled,
+			WarmAfter:   cfg.Elasticsearch.Lifecycle.WarmAfter,
+			DeleteAfter: cfg.Elasticsearch.Lifecycle.DeleteAfter,
+		},
+	)
+	if err != nil {
+		logger.Fatalf("Failed to install index template: %v", err)
+	}
+
+	flushInterval, err := setup.ParseOptionalDuration(cfg.Ingestion.FlushInterval)
+	if err != nil {
+		logger.Fatalf("Invalid flush_interval: %v", err)
+	}
+
+	bufferSize := cfg.Ingestion.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = 1000
+	}
+	logChan := make(chan kafka.LogMessage, bufferSize)
+	ingestion.ObserveBuffer("consumer", logChan)
+
+	var dlq *kafka.Producer
+	if cfg.Kafka.DeadLetterTopic != "" {
+		dlq, err = kafka.NewProducer(cfg.Kafka.Brokers, cfg.Kafka.DeadLetterTopic, kafka.ProducerOptions{
+			Security: setup.KafkaSecurity(cfg),
+		})
+		if err != nil {
+			logger.Fatalf("Failed to create dead-letter producer: %v", err)
+		}
+	}
 
-	consumer, err := kafka.NewConsumer(
-		cfg.Kafka.Brokers,
-		"log-ingestion-group",
-		[]string{cfg.Kafka.Topic},
-		logChan,
+	parsers, err := parser.NewRegistry(
+		cfg.Ingestion.Parsing.DefaultFormat,
+		parser.Defaults{
+			Service: cfg.Ingestion.Parsing.Defaults.Service,
+			Host:    cfg.Ingestion.Parsing.Defaults.Host,
+			Level:   cfg.Ingestion.Parsing.Defaults.Level,
+		},
 	)
+	if err != nil {
+		logger.Fatalf("Invalid parsing config: %v", err)
+	}
+
+	stages, err := setup.ProcessorStages(cfg)
+	if err != nil {
+		logger.Fatalf("Invalid processors config: %v", err)
+	}
+	chain, err := processor.Build(stages)
+	if err != nil {
+		logger.Fatalf("Invalid processors config: %v", err)
+	}
+
+	refreshInterval, err := setup.ParseOptionalDuration(cfg.Kafka.RefreshInterval)
+	if err != nil {
+		logger.Fatalf("Invalid refresh_interval: %v", err)
+	}
+
+	drainTimeout, err := setup.ParseOptionalDuration(cfg.Kafka.DrainTimeout)
+	if err != nil {
+		logger.Fatalf("Invalid drain_timeout: %v", err)
+	}
+
+	retry, err := parseRetryConfig(cfg)
+	if err != nil {
+		logger.Fatalf("Invalid retry config: %v", err)
+	}
+
+	consumer, err := kafka.NewConsumer(kafka.ConsumerConfig{
+		Brokers:         cfg.Kafka.Brokers,
+		Security:        setup.KafkaSecurity(cfg),
+		GroupID:         cfg.Kafka.GroupID,
+		Subscriptions:   subscriptions,
+		RefreshInterval: refreshInterval,
+		DrainTimeout:    drainTimeout,
+		Retry:           retry,
+	}, logChan, parsers, dlq)
 	if err != nil {
 		logger.Fatalf("Failed to create Kafka consumer: %v", err)
 	}
@@ -48,64 +149,259 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	var wg sync.WaitGroup
 
+	// The consumer only returns on its own once its retry policy gives up;
+	// the service then shuts down so it can be restarted.
+	consumerFailed := make(chan struct{})
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if err := consumer.Start(ctx); err != nil {
-			logger.Errorf("Kafka consumer error: %v", err)
+		if err := consumer.Start(ctx); err != nil && ctx.Err() == nil {
+			logger.Errorf("Kafka consumer stopped: %v", err)
+			close(consumerFailed)
 		}
 	}()
 
+	batcher := ingestion.NewBatchProcessor(esClient, dlq, ingestion.BatchConfig{
+		BatchSize:        cfg.Ingestion.BatchSize,
+		BatchBytes:       cfg.Ingestion.BatchBytes,
+		FlushInterval:    flushInterval,
+		Workers:          cfg.Ingestion.Workers,
+		DeterministicIDs: cfg.Ingestion.Dedup.Enabled,
+		EventIDField:     cfg.Ingestion.Dedup.EventIDField,
+	})
+
+	batchChan := logChan
+
+	if cfg.Ingestion.Multiline.Enabled {
+		flushTimeout, err := setup.ParseOptionalDuration(cfg.Ingestion.Multiline.FlushTimeout)
+		if err != nil {
+			logger.Fatalf("Invalid multiline flush_timeout: %v", err)
+		}
+
+		multiline, err := ingestion.NewMultiline(ingestion.MultilineConfig{
+			StartPattern:        cfg.Ingestion.Multiline.StartPattern,
+			ContinuationPattern: cfg.Ingestion.Multiline.ContinuationPattern,
+			FlushTimeout:        flushTimeout,
+			MaxLines:            cfg.Ingestion.Multiline.MaxLines,
+			MaxBytes:            cfg.Ingestion.Multiline.MaxBytes,
+		})
+		if err != nil {
+			logger.Fatalf("Invalid multiline config: %v", err)
+		}
+
+		combinedChan := make(chan kafka.LogMessage, bufferSize)
+		ingestion.ObserveBuffer("multiline", combinedChan)
+		wg.Add(1)
+		go func(in chan kafka.LogMessage) {
+			defer wg.Done()
+			multiline.Run(ctx, in, combinedChan)
+		}(batchChan)
+		batchChan = combinedChan
+	}
+
+	if len(cfg.Ingestion.Processors) > 0 {
+		processedChan := make(chan kafka.LogMessage, bufferSize)
+		ingestion.ObserveBuffer("processors", processedChan)
+		wg.Add(1)
+		go func(in chan kafka.LogMessage) {
+			defer wg.Done()
+			ingestion.RunProcessors(ctx, chain, in, processedChan)
+		}(batchChan)
+		batchChan = processedChan
+	}
+
+	// With the spool enabled, processed messages are written to disk and
+	// acked there, and the batch processor drains the spool instead.
+	var spool *ingestion.Spool
+	if cfg.Ingestion.Spool.Enabled {
+		spool, err = ingestion.OpenSpool(ingestion.SpoolConfig{
+			Dir:          cfg.Ingestion.Spool.Dir,
+			MaxBytes:     cfg.Ingestion.Spool.MaxBytes,
+			SegmentBytes: cfg.Ingestion.Spool.SegmentBytes,
+		})
+		if err != nil {
+			logger.Fatalf("Failed to open spool: %v", err)
+		}
+
+		spoolChan := make(chan kafka.LogMessage, bufferSize)
+		ingestion.ObserveBuffer("spool", spoolChan)
+		wg.Add(1)
+		go func(in, out chan kafka.LogMessage) {
+			defer wg.Done()
+			spool.Run(ctx, in, out)
+		}(batchChan, spoolChan)
+		batchChan = spoolChan
+	}
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		batchProcessor(ctx, esClient, logChan, logger)
+		batcher.Run(ctx, batchChan)
 	}()
 
+	var metricsServer *http.Server
+	if cfg.Ingestion.MetricsPort > 0 {
+		router := mux.NewRouter()
+		router.Handle("/metrics", promhttp.Handler())
+		router.HandleFunc("/health", handleHealth(consumer))
+
+		metricsServer = &http.Server{
+			Addr:    fmt.Sprintf(":%d", cfg.Ingestion.MetricsPort),
+			Handler: router,
+		}
+
+		go func() {
+			logger.Infof("Serving ingestion metrics on port %d", cfg.Ingestion.MetricsPort)
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Errorf("Metrics server failed: %v", err)
+			}
+		}()
+	}
+
+	// Producers that cannot reach Kafka send their logs over HTTP straight
+	// into the pipeline.
+	var ingestServer *http.Server
+	if cfg.Ingestion.HTTP.Enabled {
+		keys := make([]api.APIKey, len(cfg.Ingestion.HTTP.APIKeys))
+		for i, key := range cfg.Ingestion.HTTP.APIKeys {
+			keys[i] = api.APIKey{Producer: key.Producer, Key: key.Key}
+		}
+
+		handlers, err := api.NewIngestHandlers(logChan, parsers, keys, cfg.Ingestion.HTTP.MaxBodyBytes)
+		if err != nil {
+			logger.Fatalf("Invalid HTTP ingestion config: %v", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handlers.Run(ctx)
+		}()
+
+		router := mux.NewRouter()
+		handlers.SetupRoutes(router)
+
+		ingestServer = &http.Server{
+			Addr:         fmt.Sprintf(":%d", cfg.Ingestion.HTTP.Port),
+			Handler:      router,
+			ReadTimeout:  30 * time.Second,
+			WriteTimeout: 30 * time.Second,
+			IdleTimeout:  60 * time.Second,
+		}
+
+		go func() {
+			logger.Infof("Accepting logs over HTTP on port %d", cfg.Ingestion.HTTP.Port)
+			if err := ingestServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Fatalf("HTTP ingestion server failed: %v", err)
+			}
+		}()
+	}
+
+	if cfg.Ingestion.Syslog.Enabled {
+		syslogServer, err := syslog.NewServer(syslog.Config{
+			UDPAddr: cfg.Ingestion.Syslog.UDPAddr,
+			TCPAddr: cfg.Ingestion.Syslog.TCPAddr,
+			TLSAddr: cfg.Ingestion.Syslog.TLSAddr,
+			TLS: syslog.TLSConfig{
+				CertFile:     cfg.Ingestion.Syslog.TLS.CertFile,
+				KeyFile:      cfg.Ingestion.Syslog.TLS.KeyFile,
+				ClientCAFile: cfg.Ingestion.Syslog.TLS.ClientCAFile,
+			},
+			Service:         cfg.Ingestion.Syslog.Service,
+			MaxMessageBytes: cfg.Ingestion.Syslog.MaxMessageBytes,
+		}, logChan, parsers)
+		if err != nil {
+			logger.Fatalf("Invalid syslog config: %v", err)
+		}
+		if err := syslogServer.Listen(); err != nil {
+			logger.Fatalf("Failed to start syslog receiver: %v", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			syslogServer.Serve(ctx)
+		}()
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	<-sigChan
-	logger.Info("Shutting down...")
+	failed := false
+	select {
+	case <-sigChan:
+		logger.Info("Shutting down...")
+	case <-consumerFailed:
+		logger.Error("Shutting down after the Kafka consumer failed")
+		failed = true
+	}
+
+	if ingestServer != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := ingestServer.Shutdown(shutdownCtx); err != nil {
+			logger.Errorf("HTTP ingestion server shutdown error: %v", err)
+		}
+		shutdownCancel()
+	}
 
 	cancel()
 	consumer.Close()
 	wg.Wait()
 
+	if spool != nil {
+		if err := spool.Close(); err != nil {
+			logger.Errorf("Failed to close spool: %v", err)
+		}
+	}
+
+	if metricsServer != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+			logger.Errorf("Metrics server shutdown error: %v", err)
+		}
+		shutdownCancel()
+	}
+
+	if dlq != nil {
+		dlq.Close()
+	}
+
 	logger.Info("Shutdown complete")
+	if failed {
+		os.Exit(1)
+	}
 }
 
-func batchProcessor(ctx context.Context, esClient *elasticsearch.Client, logChan chan models.LogEntry, logger *logrus.Logger) {
-	batch := make([]models.LogEntry, 0, 100)
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			if len(batch) > 0 {
-				if err := esClient.BulkIndexLogs(ctx, batch); err != nil {
-					logger.Errorf("Failed to index final batch: %v", err)
-				}
-			}
-			return
-
-		case log := <-logChan:
-			batch = append(batch, log)
-			if len(batch) >= 100 {
-				if err := esClient.BulkIndexLogs(ctx, batch); err != nil {
-					logger.Errorf("Failed to index batch: %v", err)
-				}
-				batch = batch[:0]
-			}
+// handleHealth reports the consumer's health, answering 503 unless it is
+// consuming.
+func handleHealth(consumer *kafka.Consumer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		health := consumer.Health()
 
-		case <-ticker.C:
-			if len(batch) > 0 {
-				if err := esClient.BulkIndexLogs(ctx, batch); err != nil {
-					logger.Errorf("Failed to index timed batch: %v", err)
-				}
-				batch = batch[:0]
-			}
+		statusCode := http.StatusOK
+		if health.Status != kafka.HealthOK {
+			statusCode = http.StatusServiceUnavailable
 		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(health)
+	}
+}
+
+// parseRetryConfig reads the consumer's retry policy from the config.
+func parseRetryConfig(cfg *config.Config) (kafka.RetryConfig, error) {
+	var retry kafka.RetryConfig
+	var err error
+
+	if retry.InitialBackoff, err = setup.ParseOptionalDuration(cfg.Kafka.Retry.InitialBackoff); err != nil {
+		return retry, fmt.Errorf("invalid initial_backoff: %v", err)
 	}
-}
\ No newline at end of file
+	if retry.MaxBackoff, err = setup.ParseOptionalDuration(cfg.Kafka.Retry.MaxBackoff); err != nil {
+		return retry, fmt.Errorf("invalid max_backoff: %v", err)
+	}
+	if retry.GiveUpAfter, err = setup.ParseOptionalDuration(cfg.Kafka.Retry.GiveUpAfter); err != nil {
+		return retry, fmt.Errorf("invalid give_up_after: %v", err)
+	}
+	return retry, nil
+}
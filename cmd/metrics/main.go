@@ -25,6 +25,9 @@ func main() {
 	}
 
 	collector := prometheus.NewMetricCollector()
+	if cfg.Metrics.RetentionDays > 0 {
+		collector.SetRetention(time.Duration(cfg.Metrics.RetentionDays) * 24 * time.Hour)
+	}
 	aggregator := prometheus.NewAggregator(collector)
 
 	router := mux.NewRouter()
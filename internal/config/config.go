@@ -7,8 +7,36 @@ import (
 
 type Config struct {
 	Kafka struct {
-		Brokers []string `yaml:"brokers"`
-		Topic   string   `yaml:"topic"`
+		Brokers         []string `yaml:"brokers"`
+		Topic           string   `yaml:"topic"`
+		GroupID         string   `yaml:"group_id"`
+		RefreshInterval string   `yaml:"refresh_interval"`
+		DrainTimeout    string   `yaml:"drain_timeout"`
+		DeadLetterTopic string   `yaml:"dead_letter_topic"`
+		Subscriptions   []struct {
+			Topic   string `yaml:"topic"`
+			Pattern string `yaml:"pattern"`
+			Format  string `yaml:"format"`
+			Index   string `yaml:"index"`
+			Service string `yaml:"service"`
+		} `yaml:"subscriptions"`
+		Retry struct {
+			InitialBackoff string `yaml:"initial_backoff"`
+			MaxBackoff     string `yaml:"max_backoff"`
+			GiveUpAfter    string `yaml:"give_up_after"`
+		} `yaml:"retry"`
+		TLS struct {
+			Enabled            bool   `yaml:"enabled"`
+			CAFile             string `yaml:"ca_file"`
+			CertFile           string `yaml:"cert_file"`
+			KeyFile            string `yaml:"key_file"`
+			InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+		} `yaml:"tls"`
+		SASL struct {
+			Mechanism string `yaml:"mechanism"`
+			Username  string `yaml:"username"`
+			Password  string `yaml:"password"`
+		} `yaml:"sasl"`
 	} `yaml:"kafka"`
 	
 	Elasticsearch struct {
@@ -16,8 +44,81 @@ type Config struct {
 		Index    string   `yaml:"index"`
 		Username string   `yaml:"username"`
 		Password string   `yaml:"password"`
+
+		IndexPattern string `yaml:"index_pattern"`
+		Timezone     string `yaml:"timezone"`
+		MaxFuture    string `yaml:"max_future"`
+		MaxPast      string `yaml:"max_past"`
+		Shards       int    `yaml:"shards"`
+		Replicas     *int   `yaml:"replicas"`
+
+		Lifecycle struct {
+			Enabled     bool   `yaml:"enabled"`
+			WarmAfter   string `yaml:"warm_after"`
+			DeleteAfter string `yaml:"delete_after"`
+		} `yaml:"lifecycle"`
 	} `yaml:"elasticsearch"`
 	
+	Ingestion struct {
+		BufferSize    int    `yaml:"buffer_size"`
+		BatchSize     int    `yaml:"batch_size"`
+		BatchBytes    int    `yaml:"batch_bytes"`
+		FlushInterval string `yaml:"flush_interval"`
+		Workers       int    `yaml:"workers"`
+		MetricsPort   int    `yaml:"metrics_port"`
+		Dedup         struct {
+			Enabled      bool   `yaml:"enabled"`
+			EventIDField string `yaml:"event_id_field"`
+		} `yaml:"dedup"`
+		HTTP struct {
+			Enabled      bool  `yaml:"enabled"`
+			Port         int   `yaml:"port"`
+			MaxBodyBytes int64 `yaml:"max_body_bytes"`
+			APIKeys      []struct {
+				Producer string `yaml:"producer"`
+				Key      string `yaml:"key"`
+			} `yaml:"api_keys"`
+		} `yaml:"http"`
+		Syslog struct {
+			Enabled         bool   `yaml:"enabled"`
+			UDPAddr         string `yaml:"udp_addr"`
+			TCPAddr         string `yaml:"tcp_addr"`
+			TLSAddr         string `yaml:"tls_addr"`
+			Service         string `yaml:"service"`
+			MaxMessageBytes int    `yaml:"max_message_bytes"`
+			TLS             struct {
+				CertFile     string `yaml:"cert_file"`
+				KeyFile      string `yaml:"key_file"`
+				ClientCAFile string `yaml:"client_ca_file"`
+			} `yaml:"tls"`
+		} `yaml:"syslog"`
+		Spool         struct {
+			Enabled      bool   `yaml:"enabled"`
+			Dir          string `yaml:"dir"`
+			MaxBytes     int64  `yaml:"max_bytes"`
+			SegmentBytes int64  `yaml:"segment_bytes"`
+		} `yaml:"spool"`
+		Parsing struct {
+			DefaultFormat string `yaml:"default_format"`
+			Defaults      struct {
+				Service string `yaml:"service"`
+				Host    string `yaml:"host"`
+				Level   string `yaml:"level"`
+			} `yaml:"defaults"`
+		} `yaml:"parsing"`
+		Multiline struct {
+			Enabled             bool   `yaml:"enabled"`
+			StartPattern        string `yaml:"start_pattern"`
+			ContinuationPattern string `yaml:"continuation_pattern"`
+			FlushTimeout        string `yaml:"flush_timeout"`
+			MaxLines            int    `yaml:"max_lines"`
+			MaxBytes            int    `yaml:"max_bytes"`
+		} `yaml:"multiline"`
+		// Processors are decoded into processor stages by
+		// setup.ProcessorStages.
+		Processors []map[string]interface{} `yaml:"processors"`
+	} `yaml:"ingestion"`
+	
 	Metrics struct {
 		Port         int    `yaml:"port"`
 		Path         string `yaml:"path"`
@@ -27,8 +128,33 @@ type Config struct {
 	Alerting struct {
 		RulesPath    string `yaml:"rules_path"`
 		CheckInterval string `yaml:"check_interval"`
+		Port          int    `yaml:"port"`
+		HistoryPath   string `yaml:"history_path"`
+		HistoryMaxAge string `yaml:"history_max_age"`
+		HistoryMaxMB  int    `yaml:"history_max_mb"`
+
+		Publish struct {
+			Enabled      bool   `yaml:"enabled"`
+			Topic        string `yaml:"topic"`
+			RequiredAcks string `yaml:"required_acks"`
+			Idempotent   bool   `yaml:"idempotent"`
+			MaxRetries   int    `yaml:"max_retries"`
+		} `yaml:"publish"`
 	} `yaml:"alerting"`
 	
+	Agent struct {
+		RegistryPath string `yaml:"registry_path"`
+		PollInterval string `yaml:"poll_interval"`
+		BatchSize    int    `yaml:"batch_size"`
+		MaxLineBytes int    `yaml:"max_line_bytes"`
+		Host         string `yaml:"host"`
+		Inputs       []struct {
+			Paths   []string `yaml:"paths"`
+			Format  string   `yaml:"format"`
+			Service string   `yaml:"service"`
+		} `yaml:"inputs"`
+	} `yaml:"agent"`
+
 	Dashboard struct {
 		Port int `yaml:"port"`
 	} `yaml:"dashboard"`
@@ -47,4 +173,16 @@ func LoadConfig(path string) (*Config, error) {
 	}
 	
 	return &config, nil
-}
\ No newline at end of file
+}
+
+// SubscriptionIndices returns the index prefixes of the subscriptions that
+// write to their own indices.
+func (c *Config) SubscriptionIndices() []string {
+	var prefixes []string
+	for _, sub := range c.Kafka.Subscriptions {
+		if sub.Index != "" {
+			prefixes = append(prefixes, sub.Index)
+		}
+	}
+	return prefixes
+}
@@ -20,20 +20,89 @@ type Metric struct {
 	Type      string           `json:"type"`
 }
 
+const (
+	RuleTypeMetric  = "metric"
+	RuleTypeLog     = "log"
+	RuleTypeAnomaly = "anomaly"
+	RuleTypeStale   = "stale"
+)
+
+// NoData policies decide what a rule does for a series that has stopped
+// returning data.
+const (
+	NoDataOK       = "ok"
+	NoDataAlert    = "alert"
+	NoDataKeepLast = "keep_last"
+)
+
+const (
+	AlertStatusInactive = "inactive"
+	AlertStatusFiring   = "firing"
+	AlertStatusNoData   = "no_data"
+)
+
 type AlertRule struct {
 	Name        string            `json:"name"`
+	Type        string           `json:"type,omitempty"`
 	Query       string           `json:"query"`
+	LogQuery    *LogQuery        `json:"log_query,omitempty"`
+	Anomaly     *AnomalyConfig   `json:"anomaly,omitempty"`
 	Threshold   float64          `json:"threshold"`
 	Operator    string           `json:"operator"`
 	Duration    string           `json:"duration"`
+	NoData      string           `json:"no_data,omitempty"`
 	Labels      map[string]string `json:"labels"`
 	Annotations map[string]string `json:"annotations"`
 }
 
+// LogQuery describes a count over logs stored in Elasticsearch. When GroupBy
+// is set the count is split per distinct value of that field.
+type LogQuery struct {
+	Match   string            `json:"match,omitempty"`
+	Filters map[string]string `json:"filters,omitempty"`
+	GroupBy string            `json:"group_by,omitempty"`
+	Size    int               `json:"size,omitempty"`
+}
+
+// AnomalyConfig compares a metric query with its own history instead of a
+// fixed threshold. Method is "rolling" (the History window just before the
+// current one) or "last_week" (the History window around the same time one
+// week ago). Direction is "above", "below" or "both" (the default).
+// MinStdDev is the smallest standard deviation as a fraction of the baseline
+// mean, so flat baselines can still alert.
+type AnomalyConfig struct {
+	Method    string  `json:"method"`
+	History   string  `json:"history"`
+	Sigma     float64 `json:"sigma"`
+	Direction string  `json:"direction,omitempty"`
+	MinStdDev float64 `json:"min_stddev,omitempty"`
+}
+
+// Baseline is the expected value an anomaly alert was compared against.
+type Baseline struct {
+	Mean      float64 `json:"mean"`
+	StdDev    float64 `json:"stddev"`
+	Deviation float64 `json:"deviation"`
+}
+
 type Alert struct {
 	Rule        AlertRule         `json:"rule"`
+	Fingerprint string           `json:"fingerprint"`
 	Value       float64          `json:"value"`
 	Timestamp   time.Time        `json:"timestamp"`
+	ActiveAt    time.Time        `json:"active_at"`
 	Status      string           `json:"status"`
 	Labels      map[string]string `json:"labels"`
+	Baseline    *Baseline        `json:"baseline,omitempty"`
+}
+
+// AlertTransition records one series of a rule changing alert status.
+type AlertTransition struct {
+	Rule        string            `json:"rule"`
+	Fingerprint string            `json:"fingerprint"`
+	Labels      map[string]string `json:"labels"`
+	From        string            `json:"from"`
+	To          string            `json:"to"`
+	Value       float64           `json:"value"`
+	Timestamp   time.Time         `json:"timestamp"`
 }
\ No newline at end of file
@@ -0,0 +1,83 @@
+// Package setup turns the loaded configuration into the settings of the
+// packages the commands wire together, so every command builds them the
+// same way.
+package setup
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"awesomeProject6/internal/config"
+	"awesomeProject6/pkg/kafka"
+	"awesomeProject6/pkg/processor"
+)
+
+// KafkaSecurity returns the TLS and SASL settings every Kafka client should
+// connect with.
+func KafkaSecurity(cfg *config.Config) kafka.SecurityConfig {
+	return kafka.SecurityConfig{
+		TLS: kafka.TLSConfig{
+			Enabled:            cfg.Kafka.TLS.Enabled,
+			CAFile:             cfg.Kafka.TLS.CAFile,
+			CertFile:           cfg.Kafka.TLS.CertFile,
+			KeyFile:            cfg.Kafka.TLS.KeyFile,
+			InsecureSkipVerify: cfg.Kafka.TLS.InsecureSkipVerify,
+		},
+		SASL: kafka.SASLConfig{
+			Mechanism: cfg.Kafka.SASL.Mechanism,
+			Username:  cfg.Kafka.SASL.Username,
+			Password:  cfg.Kafka.SASL.Password,
+		},
+	}
+}
+
+// KafkaSubscriptions returns the configured topic subscriptions, or a single
+// subscription to kafka.topic when none are configured.
+func KafkaSubscriptions(cfg *config.Config) []kafka.Subscription {
+	if len(cfg.Kafka.Subscriptions) == 0 {
+		return []kafka.Subscription{{Topic: cfg.Kafka.Topic}}
+	}
+
+	subs := make([]kafka.Subscription, len(cfg.Kafka.Subscriptions))
+	for i, sub := range cfg.Kafka.Subscriptions {
+		subs[i] = kafka.Subscription{
+			Topic:   sub.Topic,
+			Pattern: sub.Pattern,
+			Format:  sub.Format,
+			Index:   sub.Index,
+			Service: sub.Service,
+		}
+	}
+	return subs
+}
+
+// ProcessorStages decodes the configured processors into stage definitions.
+// The config keeps them as plain YAML so it does not depend on the processor
+// package; the stage fields are defined once, by processor.StageConfig.
+func ProcessorStages(cfg *config.Config) ([]processor.StageConfig, error) {
+	if len(cfg.Ingestion.Processors) == 0 {
+		return nil, nil
+	}
+
+	data, err := yaml.Marshal(cfg.Ingestion.Processors)
+	if err != nil {
+		return nil, err
+	}
+
+	var stages []processor.StageConfig
+	if err := yaml.Unmarshal(data, &stages); err != nil {
+		return nil, fmt.Errorf("invalid processors: %v", err)
+	}
+	return stages, nil
+}
+
+// ParseOptionalDuration parses a duration from the config, treating an empty
+// value as zero.
+func ParseOptionalDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(value)
+}
@@ -0,0 +1,95 @@
+package setup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"awesomeProject6/internal/config"
+	"awesomeProject6/pkg/processor"
+)
+
+func TestProcessorStages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := `
+ingestion:
+  processors:
+    - type: drop
+      when:
+        - field: message
+          matches: "^GET /health"
+        - field: fields.debug
+          exists: true
+          not: true
+    - type: normalize_level
+      mapping: {sev3: ERROR}
+    - type: redact
+      redact: [email]
+      targets: [message]
+      replacement: "***"
+`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	stages, err := ProcessorStages(cfg)
+	if err != nil {
+		t.Fatalf("ProcessorStages: %v", err)
+	}
+
+	exists := true
+	want := []processor.StageConfig{
+		{
+			Type: "drop",
+			When: []processor.Condition{
+				{Field: "message", Matches: "^GET /health"},
+				{Field: "fields.debug", Exists: &exists, Not: true},
+			},
+		},
+		{Type: "normalize_level", Mapping: map[string]string{"sev3": "ERROR"}},
+		{Type: "redact", Redact: []string{"email"}, Targets: []string{"message"}, Replacement: "***"},
+	}
+	if !reflect.DeepEqual(stages, want) {
+		t.Errorf("ProcessorStages() = %+v, want %+v", stages, want)
+	}
+}
+
+func TestProcessorStagesRejectsWrongTypes(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Ingestion.Processors = []map[string]interface{}{
+		{"type": "add_tags", "tags": []interface{}{"not", "a", "map"}},
+	}
+
+	if _, err := ProcessorStages(cfg); err == nil {
+		t.Error("ProcessorStages() accepted a list where a map is expected")
+	}
+}
+
+func TestParseOptionalDuration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "", want: 0},
+		{value: "90s", want: 90 * time.Second},
+		{value: "90", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseOptionalDuration(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseOptionalDuration(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseOptionalDuration(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
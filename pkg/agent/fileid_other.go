@@ -0,0 +1,11 @@
+//go:build !unix
+
+package agent
+
+import "os"
+
+// fileIDOf identifies a file by its path where inodes are not available, so
+// a rotated file is only read up to where it was when it was renamed.
+func fileIDOf(info os.FileInfo, path string) fileID {
+	return pathID(path)
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package agent
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileIDOf identifies a file by device and inode, which stay the same when
+// the file is renamed by log rotation.
+func fileIDOf(info os.FileInfo, path string) fileID {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fileID{Device: uint64(stat.Dev), Inode: uint64(stat.Ino)}
+	}
+	return pathID(path)
+}
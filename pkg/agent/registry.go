@@ -0,0 +1,116 @@
+package agent
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// fileID identifies a file independently of its path.
+type fileID struct {
+	Device uint64 `json:"device"`
+	Inode  uint64 `json:"inode"`
+}
+
+// pathID derives an ID from a path for platforms without inodes.
+func pathID(path string) fileID {
+	h := fnv.New64a()
+	h.Write([]byte(path))
+	return fileID{Inode: h.Sum64()}
+}
+
+// registryEntry records how far a file has been shipped: Offset is the
+// position after the last line Kafka acknowledged.
+type registryEntry struct {
+	fileID
+	Path    string    `json:"path"`
+	Offset  int64     `json:"offset"`
+	Updated time.Time `json:"updated"`
+}
+
+// registry persists the read offsets of the tailed files so a restarted
+// agent resumes where it stopped.
+type registry struct {
+	path    string
+	entries map[fileID]registryEntry
+}
+
+// loadRegistry reads the registry file at path. A missing file is an empty
+// registry.
+func loadRegistry(path string) (*registry, error) {
+	r := &registry{path: path, entries: make(map[fileID]registryEntry)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []registryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		r.entries[entry.fileID] = entry
+	}
+	return r, nil
+}
+
+func (r *registry) offset(id fileID) (int64, bool) {
+	entry, ok := r.entries[id]
+	return entry.Offset, ok
+}
+
+func (r *registry) set(id fileID, path string, offset int64) {
+	r.entries[id] = registryEntry{fileID: id, Path: path, Offset: offset, Updated: time.Now()}
+}
+
+func (r *registry) remove(id fileID) {
+	delete(r.entries, id)
+}
+
+// save writes the registry to a temporary file and renames it into place,
+// so a crash leaves either the old or the new registry.
+func (r *registry) save() error {
+	entries := make([]registryEntry, 0, len(r.entries))
+	for _, entry := range r.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(r.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	tmp := r.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
@@ -0,0 +1,484 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"awesomeProject6/internal/models"
+	"awesomeProject6/pkg/kafka"
+	"awesomeProject6/pkg/parser"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	initialRetryBackoff = 1 * time.Second
+	maxRetryBackoff     = 30 * time.Second
+)
+
+// Input selects the files matching any of Paths (glob patterns) and how to
+// parse their lines: Format names a parser (the registry's default when
+// empty) and Service is the service of entries that do not name one (the
+// file name when empty).
+type Input struct {
+	Paths   []string
+	Format  string
+	Service string
+}
+
+// Config configures a Tailer. The globs are re-evaluated and the files
+// checked for new lines every PollInterval (one second by default). Lines
+// are produced in batches of up to BatchSize (500 by default) and lines
+// longer than MaxLineBytes (1 MiB by default) are split.
+type Config struct {
+	Inputs       []Input
+	RegistryPath string
+	PollInterval time.Duration
+	BatchSize    int
+	MaxLineBytes int
+}
+
+// BatchSender is the part of kafka.Producer the tailer produces with.
+type BatchSender interface {
+	SendBatch(messages []kafka.Message) error
+}
+
+// Tailer follows log files and produces their lines to Kafka as log
+// entries. A file's offset is only recorded in the registry once Kafka has
+// acknowledged its lines, so after a crash lines may be sent twice but never
+// skipped.
+//
+// Files are followed by identity rather than by path: when a file is rotated
+// by renaming, the old file is read to its end and closed once it no longer
+// matches a pattern, while the new file at the path is read from the start.
+// A file that shrinks below the current offset was truncated and is read
+// again from the start. Files in the registry that no pattern matches any
+// more, such as ones rotated while the agent was stopped, are found again by
+// identity in the directory they were recorded in and read to their end.
+type Tailer struct {
+	config   Config
+	producer BatchSender
+	parsers  *parser.Registry
+	registry *registry
+	files    map[fileID]*tailedFile
+	logger   *logrus.Logger
+}
+
+type tailedFile struct {
+	id      fileID
+	path    string
+	input   *Input
+	file    *os.File
+	reader  *bufio.Reader
+	offset  int64
+	partial []byte
+	seen    bool
+}
+
+// NewTailer creates a tailer producing to producer and loads its registry.
+func NewTailer(config Config, producer BatchSender, parsers *parser.Registry) (*Tailer, error) {
+	if len(config.Inputs) == 0 {
+		return nil, fmt.Errorf("no inputs configured")
+	}
+	for i, input := range config.Inputs {
+		if len(input.Paths) == 0 {
+			return nil, fmt.Errorf("input %d has no paths", i)
+		}
+		for _, pattern := range input.Paths {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("input %d has an invalid pattern %q: %v", i, pattern, err)
+			}
+		}
+		if input.Format != "" && !parsers.Has(input.Format) {
+			return nil, fmt.Errorf("unknown log format %q in input %d", input.Format, i)
+		}
+	}
+	if config.RegistryPath == "" {
+		config.RegistryPath = "agent-registry.json"
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Second
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 500
+	}
+	if config.MaxLineBytes <= 0 {
+		config.MaxLineBytes = 1024 * 1024
+	}
+
+	registry, err := loadRegistry(config.RegistryPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load registry: %v", err)
+	}
+
+	return &Tailer{
+		config:   config,
+		producer: producer,
+		parsers:  parsers,
+		registry: registry,
+		files:    make(map[fileID]*tailedFile),
+		logger:   logrus.New(),
+	}, nil
+}
+
+// Run tails the files until ctx is cancelled.
+func (t *Tailer) Run(ctx context.Context) {
+	defer t.closeAll()
+
+	ticker := time.NewTicker(t.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		t.poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll picks up new files and ships the new lines of every followed file.
+func (t *Tailer) poll(ctx context.Context) {
+	t.scan()
+
+	ids := make([]fileID, 0, len(t.files))
+	for id := range t.files {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return t.files[ids[i]].path < t.files[ids[j]].path
+	})
+
+	for _, id := range ids {
+		f := t.files[id]
+		if err := t.ship(ctx, f); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// The file is opened again from its recorded offset on the
+			// next scan.
+			t.logger.Errorf("Failed to read %s: %v", f.path, err)
+			f.file.Close()
+			delete(t.files, id)
+			continue
+		}
+
+		// A file no pattern matches any more was rotated away or deleted;
+		// everything written to it has been shipped by now.
+		if !f.seen {
+			t.logger.Infof("Finished %s", f.path)
+			t.finish(f)
+		}
+	}
+}
+
+// scan evaluates the globs, opening the files that are not followed yet.
+// Registry entries of files no glob matched are recovered or dropped.
+func (t *Tailer) scan() {
+	for _, f := range t.files {
+		f.seen = false
+	}
+
+	for i := range t.config.Inputs {
+		input := &t.config.Inputs[i]
+		for _, pattern := range input.Paths {
+			paths, err := filepath.Glob(pattern)
+			if err != nil {
+				continue
+			}
+			for _, path := range paths {
+				t.discover(input, path)
+			}
+		}
+	}
+
+	for id, entry := range t.registry.entries {
+		if _, ok := t.files[id]; !ok {
+			t.recover(id, entry)
+		}
+	}
+}
+
+// recover reopens a registry entry's file by identity so its unshipped tail
+// is read before the entry is dropped. The file is left unseen, so it is
+// finished once it has been read to its end.
+func (t *Tailer) recover(id fileID, entry registryEntry) {
+	input := t.inputFor(entry.Path)
+	path, info := findFile(id, entry.Path)
+	if input == nil || info == nil {
+		t.logger.Warnf("Dropping registry entry for %s, the file is gone", entry.Path)
+		t.registry.remove(id)
+		return
+	}
+
+	if f := t.open(input, path, info, id); f != nil {
+		f.seen = false
+	} else {
+		t.registry.remove(id)
+	}
+}
+
+func (t *Tailer) discover(input *Input, path string) {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+
+	id := fileIDOf(info, path)
+	if f, ok := t.files[id]; ok {
+		f.seen = true
+		f.path = path
+		return
+	}
+
+	t.open(input, path, info, id)
+}
+
+// open starts following the file at path from its registry offset and
+// returns it, or nil when it cannot be read.
+func (t *Tailer) open(input *Input, path string, info os.FileInfo, id fileID) *tailedFile {
+	file, err := os.Open(path)
+	if err != nil {
+		t.logger.Warnf("Failed to open %s: %v", path, err)
+		return nil
+	}
+
+	// Resume from the registry unless the file is now shorter, which means
+	// it was truncated or the inode was reused by another file.
+	offset, ok := t.registry.offset(id)
+	if !ok || offset > info.Size() {
+		offset = 0
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		t.logger.Warnf("Failed to seek in %s: %v", path, err)
+		file.Close()
+		return nil
+	}
+
+	t.logger.Infof("Tailing %s from offset %d", path, offset)
+	f := &tailedFile{
+		id:     id,
+		path:   path,
+		input:  input,
+		file:   file,
+		reader: bufio.NewReader(file),
+		offset: offset,
+		seen:   true,
+	}
+	t.files[id] = f
+	t.registry.set(id, path, offset)
+	return f
+}
+
+// inputFor returns the input with a pattern matching path, or nil.
+func (t *Tailer) inputFor(path string) *Input {
+	for i := range t.config.Inputs {
+		for _, pattern := range t.config.Inputs[i].Paths {
+			if matched, _ := filepath.Match(pattern, path); matched {
+				return &t.config.Inputs[i]
+			}
+		}
+	}
+	return nil
+}
+
+// findFile looks for the file with identity id, first at path and then
+// among the other files in its directory, where rotation usually renames it.
+func findFile(id fileID, path string) (string, os.FileInfo) {
+	if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && fileIDOf(info, path) == id {
+		return path, info
+	}
+
+	dir := filepath.Dir(path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", nil
+	}
+	for _, entry := range entries {
+		candidate := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(candidate)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if fileIDOf(info, candidate) == id {
+			return candidate, info
+		}
+	}
+	return "", nil
+}
+
+// ship produces the lines appended to f since the last poll, batch by batch,
+// recording the offset after each acknowledged batch.
+func (t *Tailer) ship(ctx context.Context, f *tailedFile) error {
+	if err := t.checkTruncated(f); err != nil {
+		return err
+	}
+
+	for {
+		batch, offset, err := t.readBatch(f)
+		if err != nil {
+			return err
+		}
+		if offset == f.offset {
+			return nil
+		}
+
+		if len(batch) > 0 {
+			if err := t.publish(ctx, batch); err != nil {
+				return err
+			}
+		}
+
+		f.offset = offset
+		t.registry.set(f.id, f.path, f.offset)
+		if err := t.registry.save(); err != nil {
+			t.logger.Errorf("Failed to save registry: %v", err)
+		}
+	}
+}
+
+// checkTruncated starts f over when it became shorter than what was read.
+func (t *Tailer) checkTruncated(f *tailedFile) error {
+	info, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() >= f.offset+int64(len(f.partial)) {
+		return nil
+	}
+
+	t.logger.Infof("%s was truncated, reading it from the start", f.path)
+	if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	f.reader.Reset(f.file)
+	f.offset = 0
+	f.partial = nil
+	return nil
+}
+
+// readBatch reads up to BatchSize complete lines from f and returns their
+// messages with the offset after the last of them. An incomplete last line
+// is kept until the rest of it is written, unless the file is no longer
+// matched by a pattern, in which case no more will come.
+func (t *Tailer) readBatch(f *tailedFile) ([]kafka.Message, int64, error) {
+	var batch []kafka.Message
+	offset := f.offset
+
+	for len(batch) < t.config.BatchSize {
+		chunk, err := f.reader.ReadSlice('\n')
+		f.partial = append(f.partial, chunk...)
+
+		switch {
+		case err == bufio.ErrBufferFull && len(f.partial) < t.config.MaxLineBytes:
+			continue
+		case err == io.EOF && (f.seen || len(f.partial) == 0):
+			return batch, offset, nil
+		case err != nil && err != io.EOF && err != bufio.ErrBufferFull:
+			return nil, 0, err
+		}
+
+		offset += int64(len(f.partial))
+		line := bytes.TrimRight(f.partial, "\r\n")
+		f.partial = nil
+
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		message, err := t.encode(f, line)
+		if err != nil {
+			return nil, 0, err
+		}
+		batch = append(batch, message)
+	}
+
+	return batch, offset, nil
+}
+
+// encode parses a line with its input's format into a log entry. Lines the
+// format does not accept are shipped as plain text rather than dropped.
+func (t *Tailer) encode(f *tailedFile, line []byte) (kafka.Message, error) {
+	service := f.input.Service
+	if service == "" {
+		service = filepath.Base(f.path)
+	}
+
+	received := time.Now()
+	entry, err := t.parsers.Parse(f.input.Format, line, service, received)
+	if err != nil {
+		entry, err = t.parsers.Parse(parser.FormatText, line, service, received)
+		if err != nil {
+			return kafka.Message{}, err
+		}
+	}
+	if entry.Fields == nil {
+		entry.Fields = make(map[string]interface{})
+	}
+	entry.Fields["file"] = f.path
+
+	return encodeEntry(entry, f.path)
+}
+
+// encodeEntry builds the Kafka message for entry. Messages are keyed by host
+// and file so the lines of a file stay in order on one partition.
+func encodeEntry(entry models.LogEntry, path string) (kafka.Message, error) {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	return kafka.Message{
+		Key:     []byte(entry.Host + ":" + path),
+		Value:   value,
+		Headers: map[string]string{parser.FormatHeader: parser.FormatJSON},
+	}, nil
+}
+
+// publish sends batch to Kafka, retrying with exponential backoff until it
+// is acknowledged or ctx is cancelled.
+func (t *Tailer) publish(ctx context.Context, batch []kafka.Message) error {
+	backoff := initialRetryBackoff
+
+	for {
+		err := t.producer.SendBatch(batch)
+		if err == nil {
+			return nil
+		}
+
+		t.logger.Errorf("Failed to produce %d lines, retrying in %s: %v", len(batch), backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// finish stops following f and forgets its offset.
+func (t *Tailer) finish(f *tailedFile) {
+	f.file.Close()
+	delete(t.files, f.id)
+	t.registry.remove(f.id)
+	if err := t.registry.save(); err != nil {
+		t.logger.Errorf("Failed to save registry: %v", err)
+	}
+}
+
+func (t *Tailer) closeAll() {
+	for _, f := range t.files {
+		f.file.Close()
+	}
+}
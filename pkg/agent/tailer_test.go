@@ -0,0 +1,255 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"awesomeProject6/internal/models"
+	"awesomeProject6/pkg/kafka"
+	"awesomeProject6/pkg/parser"
+)
+
+// fakeSender records the messages of every batch it is sent.
+type fakeSender struct {
+	lines []string
+}
+
+func (s *fakeSender) SendBatch(messages []kafka.Message) error {
+	for _, message := range messages {
+		var entry models.LogEntry
+		if err := json.Unmarshal(message.Value, &entry); err != nil {
+			return err
+		}
+		s.lines = append(s.lines, entry.Message)
+	}
+	return nil
+}
+
+// take returns the lines sent since the last call, sorted so files polled in
+// either order compare equal.
+func (s *fakeSender) take() []string {
+	lines := s.lines
+	s.lines = nil
+	sort.Strings(lines)
+	return lines
+}
+
+func newTestTailer(t *testing.T, dir string, sender *fakeSender) *Tailer {
+	t.Helper()
+
+	parsers, err := parser.NewRegistry(parser.FormatText, parser.Defaults{})
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+
+	tailer, err := NewTailer(Config{
+		Inputs:       []Input{{Paths: []string{filepath.Join(dir, "app.log")}}},
+		RegistryPath: filepath.Join(dir, "registry.json"),
+	}, sender, parsers)
+	if err != nil {
+		t.Fatalf("NewTailer: %v", err)
+	}
+	tailer.logger.SetOutput(io.Discard)
+	t.Cleanup(tailer.closeAll)
+	return tailer
+}
+
+func writeFile(t *testing.T, path, data string, flag int) {
+	t.Helper()
+
+	file, err := os.OpenFile(path, flag|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func appendFile(t *testing.T, path, data string) {
+	writeFile(t, path, data, os.O_APPEND)
+}
+
+func expectLines(t *testing.T, sender *fakeSender, want ...string) {
+	t.Helper()
+
+	sort.Strings(want)
+	got := sender.take()
+	if len(got) == 0 && len(want) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("shipped %q, want %q", got, want)
+	}
+}
+
+func TestTailerRenameRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	sender := &fakeSender{}
+	tailer := newTestTailer(t, dir, sender)
+
+	appendFile(t, path, "one\ntwo\n")
+	tailer.poll(context.Background())
+	expectLines(t, sender, "one", "two")
+
+	// Lines written just before the rename still belong to the old file.
+	appendFile(t, path, "three\n")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "four\n")
+
+	tailer.poll(context.Background())
+	expectLines(t, sender, "three", "four")
+
+	if len(tailer.files) != 1 || len(tailer.registry.entries) != 1 {
+		t.Errorf("following %d files with %d registry entries, want only the new file", len(tailer.files), len(tailer.registry.entries))
+	}
+
+	appendFile(t, path, "five\n")
+	tailer.poll(context.Background())
+	expectLines(t, sender, "five")
+}
+
+func TestTailerCopyTruncate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	sender := &fakeSender{}
+	tailer := newTestTailer(t, dir, sender)
+
+	appendFile(t, path, "first line\nsecond line\n")
+	tailer.poll(context.Background())
+	expectLines(t, sender, "first line", "second line")
+
+	writeFile(t, path, "new\n", os.O_TRUNC)
+	tailer.poll(context.Background())
+	expectLines(t, sender, "new")
+
+	appendFile(t, path, "more\n")
+	tailer.poll(context.Background())
+	expectLines(t, sender, "more")
+}
+
+func TestTailerPartialLastLine(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	sender := &fakeSender{}
+	tailer := newTestTailer(t, dir, sender)
+
+	appendFile(t, path, "complete\npart")
+	tailer.poll(context.Background())
+	expectLines(t, sender, "complete")
+
+	offset, _ := tailer.registry.offset(tailer.registry.entryFor(t, path))
+	if offset != int64(len("complete\n")) {
+		t.Errorf("registry offset %d, want %d", offset, len("complete\n"))
+	}
+
+	appendFile(t, path, "ial\nunterminated")
+	tailer.poll(context.Background())
+	expectLines(t, sender, "partial")
+
+	// Once the file is rotated away nothing more will be written to it, so
+	// its unterminated last line is shipped as it is.
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	tailer.poll(context.Background())
+	expectLines(t, sender, "unterminated")
+}
+
+func TestTailerRestartFromRegistry(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	sender := &fakeSender{}
+
+	first := newTestTailer(t, dir, sender)
+	appendFile(t, path, "one\ntwo\n")
+	first.poll(context.Background())
+	expectLines(t, sender, "one", "two")
+	first.closeAll()
+
+	appendFile(t, path, "three\n")
+
+	second := newTestTailer(t, dir, sender)
+	second.poll(context.Background())
+	expectLines(t, sender, "three")
+}
+
+func TestTailerRestartAfterRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	sender := &fakeSender{}
+
+	first := newTestTailer(t, dir, sender)
+	appendFile(t, path, "one\n")
+	first.poll(context.Background())
+	expectLines(t, sender, "one")
+	first.closeAll()
+
+	// While the agent is stopped the file gets more lines and is rotated to
+	// a name the pattern does not match.
+	appendFile(t, path, "two\n")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "three\n")
+
+	second := newTestTailer(t, dir, sender)
+	second.poll(context.Background())
+	expectLines(t, sender, "two", "three")
+
+	if len(second.files) != 1 || len(second.registry.entries) != 1 {
+		t.Errorf("following %d files with %d registry entries, want only the new file", len(second.files), len(second.registry.entries))
+	}
+
+	// A restart after the rotated file was finished does not read it again.
+	second.closeAll()
+	third := newTestTailer(t, dir, sender)
+	third.poll(context.Background())
+	expectLines(t, sender)
+}
+
+func TestTailerDropsEntriesOfDeletedFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	sender := &fakeSender{}
+
+	first := newTestTailer(t, dir, sender)
+	appendFile(t, path, "one\n")
+	first.poll(context.Background())
+	first.closeAll()
+	sender.take()
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	second := newTestTailer(t, dir, sender)
+	second.poll(context.Background())
+	expectLines(t, sender)
+	if len(second.registry.entries) != 0 {
+		t.Errorf("registry kept %d entries for a deleted file", len(second.registry.entries))
+	}
+}
+
+// entryFor returns the ID of the registry entry recorded for path.
+func (r *registry) entryFor(t *testing.T, path string) fileID {
+	t.Helper()
+
+	for id, entry := range r.entries {
+		if entry.Path == path {
+			return id
+		}
+	}
+	t.Fatalf("no registry entry for %s", path)
+	return fileID{}
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"awesomeProject6/internal/models"
+	"awesomeProject6/pkg/rules"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// AlertHandlers serves the alerting service API.
+type AlertHandlers struct {
+	engine  *rules.Engine
+	history *rules.History
+	logger  *logrus.Logger
+}
+
+func NewAlertHandlers(engine *rules.Engine, history *rules.History) *AlertHandlers {
+	return &AlertHandlers{
+		engine:  engine,
+		history: history,
+		logger:  logrus.New(),
+	}
+}
+
+func (h *AlertHandlers) SetupRoutes(router *mux.Router) {
+	api := router.PathPrefix("/api/v1").Subrouter()
+
+	api.HandleFunc("/alerts", h.listAlerts).Methods("GET")
+	api.HandleFunc("/alerts/history", h.alertHistory).Methods("GET")
+	api.HandleFunc("/rules", h.listRules).Methods("GET")
+	api.HandleFunc("/health", h.healthCheck).Methods("GET")
+}
+
+// listAlerts returns active alerts. The "state" and "rule" parameters filter
+// on status and rule name; any other parameter is matched as a label.
+func (h *AlertHandlers) listAlerts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	state := query.Get("state")
+	rule := query.Get("rule")
+	labels := labelFilters(query, "state", "rule")
+
+	alerts := make([]models.Alert, 0)
+	for _, alert := range h.engine.ActiveAlerts() {
+		if state != "" && alert.Status != state {
+			continue
+		}
+		if rule != "" && alert.Rule.Name != rule {
+			continue
+		}
+		if !hasLabels(alert.Labels, labels) {
+			continue
+		}
+		alerts = append(alerts, alert)
+	}
+
+	response := map[string]interface{}{
+		"alerts": alerts,
+		"total":  len(alerts),
+	}
+
+	writeJSONResponse(w, h.logger, http.StatusOK, response)
+}
+
+func (h *AlertHandlers) listRules(w http.ResponseWriter, r *http.Request) {
+	statuses := h.engine.RuleStatuses()
+
+	response := map[string]interface{}{
+		"rules": statuses,
+		"total": len(statuses),
+	}
+
+	writeJSONResponse(w, h.logger, http.StatusOK, response)
+}
+
+// alertHistory returns recorded state transitions. "from" and "to" are unix
+// timestamps; "limit" defaults to 100.
+func (h *AlertHandlers) alertHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	historyQuery := rules.HistoryQuery{
+		Rule:        query.Get("rule"),
+		Fingerprint: query.Get("fingerprint"),
+		Status:      query.Get("state"),
+		Labels:      labelFilters(query, "rule", "fingerprint", "state", "from", "to", "limit"),
+		Limit:       100,
+	}
+
+	if fromStr := query.Get("from"); fromStr != "" {
+		from, err := strconv.ParseInt(fromStr, 10, 64)
+		if err != nil {
+			writeErrorResponse(w, h.logger, http.StatusBadRequest, "Invalid from timestamp")
+			return
+		}
+		historyQuery.Since = time.Unix(from, 0)
+	}
+
+	if toStr := query.Get("to"); toStr != "" {
+		to, err := strconv.ParseInt(toStr, 10, 64)
+		if err != nil {
+			writeErrorResponse(w, h.logger, http.StatusBadRequest, "Invalid to timestamp")
+			return
+		}
+		historyQuery.Until = time.Unix(to, 0)
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			writeErrorResponse(w, h.logger, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		historyQuery.Limit = limit
+	}
+
+	transitions, err := h.history.Query(historyQuery)
+	if err != nil {
+		h.logger.Errorf("Failed to query alert history: %v", err)
+		writeErrorResponse(w, h.logger, http.StatusInternalServerError, "Failed to query alert history")
+		return
+	}
+	if transitions == nil {
+		transitions = []models.AlertTransition{}
+	}
+
+	response := map[string]interface{}{
+		"transitions": transitions,
+		"total":       len(transitions),
+	}
+
+	writeJSONResponse(w, h.logger, http.StatusOK, response)
+}
+
+func (h *AlertHandlers) healthCheck(w http.ResponseWriter, r *http.Request) {
+	response := map[string]interface{}{
+		"status":    "healthy",
+		"timestamp": time.Now().Unix(),
+		"version":   "1.0.0",
+	}
+
+	writeJSONResponse(w, h.logger, http.StatusOK, response)
+}
+
+func labelFilters(values map[string][]string, reserved ...string) map[string]string {
+	labels := make(map[string]string)
+	for key, vals := range values {
+		if len(vals) == 0 || contains(reserved, key) {
+			continue
+		}
+		labels[key] = vals[0]
+	}
+	return labels
+}
+
+func hasLabels(labels, matchers map[string]string) bool {
+	for k, v := range matchers {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -83,28 +83,35 @@ func (h *Handlers) queryMetrics(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var value float64
+	var ok bool
 	switch function {
 	case "sum":
-		value = h.aggregator.Sum(metric, labels, duration)
+		value, ok = h.aggregator.Sum(metric, labels, duration)
 	case "avg":
-		value = h.aggregator.Average(metric, labels, duration)
+		value, ok = h.aggregator.Average(metric, labels, duration)
 	case "max":
-		value = h.aggregator.Max(metric, labels, duration)
+		value, ok = h.aggregator.Max(metric, labels, duration)
 	case "rate":
-		value = h.aggregator.Rate(metric, labels, duration)
+		value, ok = h.aggregator.Rate(metric, labels, duration)
 	case "p95":
-		value = h.aggregator.Percentile(metric, labels, duration, 95)
+		value, ok = h.aggregator.Percentile(metric, labels, duration, 95)
 	case "p99":
-		value = h.aggregator.Percentile(metric, labels, duration, 99)
+		value, ok = h.aggregator.Percentile(metric, labels, duration, 99)
 	default:
 		h.writeErrorResponse(w, http.StatusBadRequest, "Unknown function")
 		return
 	}
 
+	// A missing series is reported as a null value rather than zero.
+	var result interface{}
+	if ok {
+		result = value
+	}
+
 	response := map[string]interface{}{
 		"metric":   metric,
 		"function": function,
-		"value":    value,
+		"value":    result,
 		"duration": durationStr,
 		"labels":   labels,
 	}
@@ -167,20 +174,28 @@ func (h *Handlers) healthCheck(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handlers) writeJSONResponse(w http.ResponseWriter, statusCode int, data interface{}) {
+	writeJSONResponse(w, h.logger, statusCode, data)
+}
+
+func (h *Handlers) writeErrorResponse(w http.ResponseWriter, statusCode int, message string) {
+	writeErrorResponse(w, h.logger, statusCode, message)
+}
+
+func writeJSONResponse(w http.ResponseWriter, logger *logrus.Logger, statusCode int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 
 	if err := json.NewEncoder(w).Encode(data); err != nil {
-		h.logger.Errorf("Failed to encode JSON response: %v", err)
+		logger.Errorf("Failed to encode JSON response: %v", err)
 	}
 }
 
-func (h *Handlers) writeErrorResponse(w http.ResponseWriter, statusCode int, message string) {
+func writeErrorResponse(w http.ResponseWriter, logger *logrus.Logger, statusCode int, message string) {
 	response := map[string]interface{}{
 		"error":   message,
 		"status":  statusCode,
 		"timestamp": time.Now().Unix(),
 	}
 
-	h.writeJSONResponse(w, statusCode, response)
+	writeJSONResponse(w, logger, statusCode, response)
 }
\ No newline at end of file
@@ -0,0 +1,279 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"awesomeProject6/internal/models"
+	"awesomeProject6/pkg/kafka"
+	"awesomeProject6/pkg/parser"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	httpEntriesAccepted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_ingestion_http_entries_total",
+		Help: "Log entries accepted by the HTTP ingestion endpoint.",
+	}, []string{"producer"})
+
+	httpRequestsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_ingestion_http_rejected_total",
+		Help: "Requests to the HTTP ingestion endpoint that were not fully accepted.",
+	}, []string{"producer", "reason"})
+)
+
+// APIKey identifies a producer allowed to send logs over HTTP. Entries
+// without a service get the producer's name.
+type APIKey struct {
+	Producer string
+	Key      string
+}
+
+// IngestHandlers accepts log entries over HTTP and feeds them into the
+// ingestion pipeline alongside the Kafka consumer. Entries wait in a queue of
+// their own, which only the handlers write to, so a request can check that
+// all of its entries fit before enqueuing any of them.
+type IngestHandlers struct {
+	logChan      chan<- kafka.LogMessage
+	queue        chan kafka.LogMessage
+	enqueueMutex sync.Mutex
+	parsers      *parser.Registry
+	keys         []APIKey
+	maxBodyBytes int64
+	logger       *logrus.Logger
+}
+
+// NewIngestHandlers creates handlers that queue accepted entries for Run to
+// send to logChan. The queue holds as many entries as logChan's buffer.
+// Request bodies larger than maxBodyBytes, after decompression, are
+// rejected; zero allows 10 MiB.
+func NewIngestHandlers(logChan chan<- kafka.LogMessage, parsers *parser.Registry, keys []APIKey, maxBodyBytes int64) (*IngestHandlers, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("HTTP ingestion requires at least one API key")
+	}
+	for _, key := range keys {
+		if key.Producer == "" || key.Key == "" {
+			return nil, fmt.Errorf("API keys need a producer and a key")
+		}
+	}
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = 10 * 1024 * 1024
+	}
+
+	return &IngestHandlers{
+		logChan:      logChan,
+		queue:        make(chan kafka.LogMessage, queueSize(logChan)),
+		parsers:      parsers,
+		keys:         keys,
+		maxBodyBytes: maxBodyBytes,
+		logger:       logrus.New(),
+	}, nil
+}
+
+// queueSize returns the capacity of the HTTP queue for logChan.
+func queueSize(logChan chan<- kafka.LogMessage) int {
+	if size := cap(logChan); size > 0 {
+		return size
+	}
+	return 1000
+}
+
+// Run sends queued entries into the pipeline until ctx is cancelled.
+func (h *IngestHandlers) Run(ctx context.Context) {
+	for {
+		select {
+		case msg := <-h.queue:
+			select {
+			case h.logChan <- msg:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (h *IngestHandlers) SetupRoutes(router *mux.Router) {
+	api := router.PathPrefix("/api/v1").Subrouter()
+
+	api.HandleFunc("/logs", h.ingestLogs).Methods("POST")
+}
+
+// ingestLogs accepts a single LogEntry, a JSON array of entries or
+// newline-delimited entries, optionally gzip compressed. The request is
+// accepted or rejected as a whole: if any entry is invalid it is rejected
+// with 400, and if the queue has no room for all of its entries with 429, so
+// clients can retry it without duplicating entries.
+func (h *IngestHandlers) ingestLogs(w http.ResponseWriter, r *http.Request) {
+	producer, ok := h.authenticate(r)
+	if !ok {
+		httpRequestsRejected.WithLabelValues("", "unauthorized").Inc()
+		writeErrorResponse(w, h.logger, http.StatusUnauthorized, "Missing or invalid API key")
+		return
+	}
+
+	body, status, err := h.readBody(w, r)
+	if err != nil {
+		httpRequestsRejected.WithLabelValues(producer, "invalid").Inc()
+		writeErrorResponse(w, h.logger, status, err.Error())
+		return
+	}
+
+	entries, err := h.decode(body, producer, time.Now())
+	if err != nil {
+		httpRequestsRejected.WithLabelValues(producer, "invalid").Inc()
+		writeErrorResponse(w, h.logger, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if len(entries) > cap(h.queue) {
+		httpRequestsRejected.WithLabelValues(producer, "too_many_entries").Inc()
+		writeErrorResponse(w, h.logger, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("request has %d entries, more than the %d that can be buffered", len(entries), cap(h.queue)))
+		return
+	}
+
+	if !h.enqueue(entries) {
+		httpRequestsRejected.WithLabelValues(producer, "buffer_full").Inc()
+		w.Header().Set("Retry-After", "1")
+		writeErrorResponse(w, h.logger, http.StatusTooManyRequests, "Ingestion buffer is full")
+		return
+	}
+	httpEntriesAccepted.WithLabelValues(producer).Add(float64(len(entries)))
+
+	writeJSONResponse(w, h.logger, http.StatusAccepted, map[string]interface{}{
+		"accepted": len(entries),
+	})
+}
+
+// enqueue queues every entry, or none of them if the queue has no room for
+// all. Only handlers write to the queue, and they do so one at a time, so
+// the room checked for cannot be taken before the entries are sent.
+func (h *IngestHandlers) enqueue(entries []models.LogEntry) bool {
+	h.enqueueMutex.Lock()
+	defer h.enqueueMutex.Unlock()
+
+	if cap(h.queue)-len(h.queue) < len(entries) {
+		return false
+	}
+	for _, entry := range entries {
+		h.queue <- kafka.NewLogMessage(entry, nil)
+	}
+	return true
+}
+
+// authenticate returns the producer owning the API key sent in the
+// X-API-Key header or as a bearer token.
+func (h *IngestHandlers) authenticate(r *http.Request) (string, bool) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if key == "" {
+		return "", false
+	}
+
+	for _, candidate := range h.keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(candidate.Key)) == 1 {
+			return candidate.Producer, true
+		}
+	}
+	return "", false
+}
+
+// readBody reads the request body, decompressing it if needed, and returns
+// the status to answer with when that fails.
+func (h *IngestHandlers) readBody(w http.ResponseWriter, r *http.Request) ([]byte, int, error) {
+	var reader io.Reader = http.MaxBytesReader(w, r.Body, h.maxBodyBytes)
+
+	if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid gzip body: %v", err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	body, err := io.ReadAll(io.LimitReader(reader, h.maxBodyBytes+1))
+	if err != nil {
+		if _, tooLarge := err.(*http.MaxBytesError); tooLarge {
+			return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("request body exceeds %d bytes", h.maxBodyBytes)
+		}
+		return nil, http.StatusBadRequest, fmt.Errorf("failed to read body: %v", err)
+	}
+	if int64(len(body)) > h.maxBodyBytes {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("request body exceeds %d bytes", h.maxBodyBytes)
+	}
+	return body, http.StatusOK, nil
+}
+
+// decode splits body into entries and validates them. A body starting with
+// "[" is an array; one that is a single valid JSON value is one entry; any
+// other body is read as one entry per line.
+func (h *IngestHandlers) decode(body []byte, producer string, received time.Time) ([]models.LogEntry, error) {
+	body = bytes.TrimSpace(body)
+
+	var raws []json.RawMessage
+	switch {
+	case len(body) == 0:
+		return nil, fmt.Errorf("request body is empty")
+	case body[0] == '[':
+		if err := json.Unmarshal(body, &raws); err != nil {
+			return nil, fmt.Errorf("invalid JSON array: %v", err)
+		}
+	case json.Valid(body):
+		raws = []json.RawMessage{body}
+	default:
+		for _, line := range bytes.Split(body, []byte("\n")) {
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				raws = append(raws, line)
+			}
+		}
+	}
+
+	if len(raws) == 0 {
+		return nil, fmt.Errorf("request contains no log entries")
+	}
+
+	entries := make([]models.LogEntry, len(raws))
+	for i, raw := range raws {
+		entry, err := h.parsers.Parse(parser.FormatJSON, raw, producer, received)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %v", i, err)
+		}
+		if err := validateEntry(&entry); err != nil {
+			return nil, fmt.Errorf("entry %d: %v", i, err)
+		}
+		entries[i] = entry
+	}
+	return entries, nil
+}
+
+// validateEntry checks that an entry has a message and a known level, which
+// it normalizes.
+func validateEntry(entry *models.LogEntry) error {
+	if strings.TrimSpace(entry.Message) == "" {
+		return fmt.Errorf("message is required")
+	}
+
+	level, ok := parser.NormalizeLevel(entry.Level)
+	if !ok {
+		return fmt.Errorf("unknown level %q", entry.Level)
+	}
+	entry.Level = level
+	return nil
+}
@@ -15,14 +15,35 @@ import (
 type Client struct {
 	es     *elasticsearch.Client
 	index  string
-	logger *logrus.Logger
+	router *IndexRouter
+	// prefixes are the other index prefixes logs are written under, such as
+	// those of subscriptions with their own index.
+	prefixes []string
+	logger   *logrus.Logger
 }
 
-func NewClient(urls []string, username, password, index string) (*Client, error) {
+// ClientConfig configures a Client. Index is the prefix of the dated indices
+// logs are written to; IndexPattern, Timezone, MaxFuture and MaxPast control
+// how a log's timestamp maps to one of them (see IndexRouter). Prefixes
+// lists the other index prefixes logs are written under; CountLogs and
+// CountLogsByTerm search them along with Index.
+type ClientConfig struct {
+	URLs         []string
+	Username     string
+	Password     string
+	Index        string
+	IndexPattern string
+	Timezone     string
+	MaxFuture    time.Duration
+	MaxPast      time.Duration
+	Prefixes     []string
+}
+
+func NewClient(config ClientConfig) (*Client, error) {
 	cfg := elasticsearch.Config{
-		Addresses: urls,
-		Username:  username,
-		Password:  password,
+		Addresses: config.URLs,
+		Username:  config.Username,
+		Password:  config.Password,
 	}
 
 	es, err := elasticsearch.NewClient(cfg)
@@ -30,119 +51,206 @@ func NewClient(urls []string, username, password, index string) (*Client, error)
 		return nil, err
 	}
 
+	router, err := NewIndexRouter(config.Index, config.IndexPattern, config.Timezone, config.MaxFuture, config.MaxPast)
+	if err != nil {
+		return nil, err
+	}
+
 	logger := logrus.New()
 
 	client := &Client{
-		es:     es,
-		index:  index,
-		logger: logger,
-	}
-
-	if err := client.createIndexIfNotExists(); err != nil {
-		return nil, err
+		es:       es,
+		index:    config.Index,
+		router:   router,
+		prefixes: config.Prefixes,
+		logger:   logger,
 	}
 
 	return client, nil
 }
 
-func (c *Client) createIndexIfNotExists() error {
-	mapping := map[string]interface{}{
-		"mappings": map[string]interface{}{
-			"properties": map[string]interface{}{
-				"timestamp": map[string]interface{}{
-					"type": "date",
-				},
-				"level": map[string]interface{}{
-					"type": "keyword",
-				},
-				"message": map[string]interface{}{
-					"type": "text",
-				},
-				"service": map[string]interface{}{
-					"type": "keyword",
-				},
-				"host": map[string]interface{}{
-					"type": "keyword",
-				},
-				"tags": map[string]interface{}{
-					"type": "object",
-				},
-				"fields": map[string]interface{}{
-					"type": "object",
-				},
-			},
-		},
-	}
-
-	body, err := json.Marshal(mapping)
+func (c *Client) IndexLog(ctx context.Context, log models.LogEntry) error {
+	body, err := json.Marshal(log)
 	if err != nil {
 		return err
 	}
 
-	req := esapi.IndicesCreateRequest{
-		Index: c.index,
+	if c.router.TooOld(log.Timestamp) {
+		return fmt.Errorf("log timestamp %s is older than max_past", log.Timestamp.Format(time.RFC3339))
+	}
+	indexName := c.router.IndexFor(log.Timestamp)
+
+	req := esapi.IndexRequest{
+		Index: indexName,
 		Body:  bytes.NewReader(body),
 	}
 
-	res, err := req.Do(context.Background(), c.es)
+	res, err := req.Do(ctx, c.es)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
 
-	if res.IsError() && res.StatusCode != 400 {
-		return fmt.Errorf("failed to create index: %s", res.Status())
+	if res.IsError() {
+		return fmt.Errorf("failed to index log: %s", res.Status())
 	}
 
 	return nil
 }
 
-func (c *Client) IndexLog(ctx context.Context, log models.LogEntry) error {
-	body, err := json.Marshal(log)
-	if err != nil {
-		return err
-	}
+// BulkReport summarises a BulkIndexLogs call. Failed holds every document
+// that was not indexed, identified by its position in the input slice.
+// Duplicates counts documents with an ID that already existed; they are
+// included in Indexed.
+type BulkReport struct {
+	Indexed    int
+	Duplicates int
+	Attempts   int
+	Failed     []BulkFailure
+}
 
-	indexName := fmt.Sprintf("%s-%s", c.index, time.Now().Format("2006.01.02"))
+// BulkFailure describes a document Elasticsearch did not index. Retryable
+// failures (429 and 5xx) may succeed if sent again later; the others need the
+// document or the mapping to change.
+type BulkFailure struct {
+	Position  int
+	Status    int
+	Type      string
+	Reason    string
+	Retryable bool
+}
 
-	req := esapi.IndexRequest{
-		Index: indexName,
-		Body:  bytes.NewReader(body),
+// BulkDocument is a log entry to bulk index. Index overrides the client's
+// index prefix when set. A document with an ID is only created if no
+// document with that ID exists yet, so sending it again is harmless;
+// without one Elasticsearch generates the ID.
+type BulkDocument struct {
+	Entry models.LogEntry
+	Index string
+	ID    string
+}
+
+const (
+	bulkMaxRetries   = 3
+	bulkRetryBackoff = 500 * time.Millisecond
+)
+
+// BulkIndexLogs indexes logs into the client's index with the bulk API.
+func (c *Client) BulkIndexLogs(ctx context.Context, logs []models.LogEntry) (*BulkReport, error) {
+	docs := make([]BulkDocument, len(logs))
+	for i, log := range logs {
+		docs[i] = BulkDocument{Entry: log}
 	}
+	return c.BulkIndex(ctx, docs)
+}
 
-	res, err := req.Do(ctx, c.es)
-	if err != nil {
-		return err
+// BulkIndex indexes docs with the bulk API. Documents rejected with a
+// retryable status are resent with exponential backoff up to bulkMaxRetries
+// times. The returned report is never nil; err is set when a bulk request
+// itself failed, in which case every document not yet indexed is reported as
+// a retryable failure.
+func (c *Client) BulkIndex(ctx context.Context, docs []BulkDocument) (*BulkReport, error) {
+	report := &BulkReport{}
+
+	pending := make([]int, 0, len(docs))
+	bodies := make([][]byte, len(docs))
+	for i, doc := range docs {
+		if c.router.TooOld(doc.Entry.Timestamp) {
+			report.Failed = append(report.Failed, BulkFailure{
+				Position: i,
+				Type:     "timestamp_out_of_range",
+				Reason:   fmt.Sprintf("timestamp %s is older than max_past", doc.Entry.Timestamp.Format(time.RFC3339)),
+			})
+			continue
+		}
+		logBytes, err := json.Marshal(doc.Entry)
+		if err != nil {
+			report.Failed = append(report.Failed, BulkFailure{
+				Position: i,
+				Type:     "serialization_error",
+				Reason:   err.Error(),
+			})
+			continue
+		}
+		bodies[i] = logBytes
+		pending = append(pending, i)
 	}
-	defer res.Body.Close()
 
-	if res.IsError() {
-		return fmt.Errorf("failed to index log: %s", res.Status())
+	backoff := bulkRetryBackoff
+	for attempt := 0; len(pending) > 0; attempt++ {
+		report.Attempts++
+
+		retry, err := c.bulk(ctx, docs, bodies, pending, report)
+		if err != nil {
+			for _, pos := range pending {
+				report.Failed = append(report.Failed, BulkFailure{
+					Position:  pos,
+					Type:      "request_error",
+					Reason:    err.Error(),
+					Retryable: true,
+				})
+			}
+			return report, err
+		}
+
+		if len(retry) == 0 {
+			break
+		}
+		if attempt >= bulkMaxRetries {
+			report.Failed = append(report.Failed, retry...)
+			break
+		}
+
+		c.logger.Warnf("Retrying %d of %d documents rejected by bulk request in %s", len(retry), len(docs), backoff)
+
+		select {
+		case <-ctx.Done():
+			report.Failed = append(report.Failed, retry...)
+			return report, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+
+		pending = pending[:0]
+		for _, failure := range retry {
+			pending = append(pending, failure.Position)
+		}
 	}
 
-	return nil
+	return report, nil
 }
 
-func (c *Client) BulkIndexLogs(ctx context.Context, logs []models.LogEntry) error {
+// bulk sends the documents at the given positions in one bulk request. It
+// records indexed documents and permanent failures in report and returns the
+// retryable failures.
+func (c *Client) bulk(ctx context.Context, docs []BulkDocument, bodies [][]byte, positions []int, report *BulkReport) ([]BulkFailure, error) {
 	var buf bytes.Buffer
 
-	for _, log := range logs {
-		indexName := fmt.Sprintf("%s-%s", c.index, time.Now().Format("2006.01.02"))
-		
+	for _, pos := range positions {
+		prefix := docs[pos].Index
+		if prefix == "" {
+			prefix = c.index
+		}
+		indexName := c.router.IndexWithPrefix(prefix, docs[pos].Entry.Timestamp)
+
 		meta := map[string]interface{}{
 			"index": map[string]interface{}{
 				"_index": indexName,
 			},
 		}
+		if id := docs[pos].ID; id != "" {
+			meta = map[string]interface{}{
+				"create": map[string]interface{}{
+					"_index": indexName,
+					"_id":    id,
+				},
+			}
+		}
 		metaBytes, _ := json.Marshal(meta)
 		buf.Write(metaBytes)
 		buf.WriteByte('\n')
 
-		logBytes, err := json.Marshal(log)
-		if err != nil {
-			return err
-		}
-		buf.Write(logBytes)
+		buf.Write(bodies[pos])
 		buf.WriteByte('\n')
 	}
 
@@ -152,13 +260,201 @@ func (c *Client) BulkIndexLogs(ctx context.Context, logs []models.LogEntry) erro
 
 	res, err := req.Do(ctx, c.es)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer res.Body.Close()
 
 	if res.IsError() {
-		return fmt.Errorf("bulk index failed: %s", res.Status())
+		return nil, fmt.Errorf("bulk index failed: %s", res.Status())
 	}
 
-	return nil
-}
\ No newline at end of file
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+			Error  struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode bulk response: %v", err)
+	}
+	if len(result.Items) != len(positions) {
+		return nil, fmt.Errorf("bulk response has %d items for %d documents", len(result.Items), len(positions))
+	}
+
+	var retry []BulkFailure
+	for i, item := range result.Items {
+		for op, outcome := range item {
+			if outcome.Status < 300 {
+				report.Indexed++
+				continue
+			}
+			if op == "create" && outcome.Status == 409 {
+				report.Indexed++
+				report.Duplicates++
+				continue
+			}
+
+			failure := BulkFailure{
+				Position:  positions[i],
+				Status:    outcome.Status,
+				Type:      outcome.Error.Type,
+				Reason:    outcome.Error.Reason,
+				Retryable: outcome.Status == 429 || outcome.Status >= 500,
+			}
+			if failure.Retryable {
+				retry = append(retry, failure)
+			} else {
+				report.Failed = append(report.Failed, failure)
+			}
+		}
+	}
+
+	return retry, nil
+}
+
+// LogFilter selects the logs counted by CountLogs and CountLogsByTerm.
+// Match is a phrase matched against the message, Terms are exact field values.
+type LogFilter struct {
+	Match string
+	Terms map[string]string
+	From  time.Time
+	To    time.Time
+}
+
+func (c *Client) CountLogs(ctx context.Context, filter LogFilter) (int64, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"query": filter.query(),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	req := esapi.CountRequest{
+		Index: c.searchIndices(),
+		Body:  bytes.NewReader(body),
+	}
+
+	res, err := req.Do(ctx, c.es)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return 0, fmt.Errorf("count logs failed: %s", res.Status())
+	}
+
+	var result struct {
+		Count int64 `json:"count"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+
+	return result.Count, nil
+}
+
+// CountLogsByTerm counts matching logs per distinct value of field, returning
+// at most size buckets.
+func (c *Client) CountLogsByTerm(ctx context.Context, filter LogFilter, field string, size int) (map[string]int64, error) {
+	if size <= 0 {
+		size = 10
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"size":  0,
+		"query": filter.query(),
+		"aggs": map[string]interface{}{
+			"by_term": map[string]interface{}{
+				"terms": map[string]interface{}{
+					"field": field,
+					"size":  size,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req := esapi.SearchRequest{
+		Index: c.searchIndices(),
+		Body:  bytes.NewReader(body),
+	}
+
+	res, err := req.Do(ctx, c.es)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("terms count failed: %s", res.Status())
+	}
+
+	var result struct {
+		Aggregations struct {
+			ByTerm struct {
+				Buckets []struct {
+					Key      interface{} `json:"key"`
+					DocCount int64       `json:"doc_count"`
+				} `json:"buckets"`
+			} `json:"by_term"`
+		} `json:"aggregations"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(result.Aggregations.ByTerm.Buckets))
+	for _, bucket := range result.Aggregations.ByTerm.Buckets {
+		counts[fmt.Sprint(bucket.Key)] = bucket.DocCount
+	}
+
+	return counts, nil
+}
+
+// searchIndices returns the patterns matching every index the client's logs
+// may be in.
+func (c *Client) searchIndices() []string {
+	return c.indexPatterns()
+}
+
+func (f LogFilter) query() map[string]interface{} {
+	filters := []interface{}{
+		map[string]interface{}{
+			"range": map[string]interface{}{
+				"timestamp": map[string]interface{}{
+					"gte": f.From.Format(time.RFC3339Nano),
+					"lte": f.To.Format(time.RFC3339Nano),
+				},
+			},
+		},
+	}
+
+	for field, value := range f.Terms {
+		filters = append(filters, map[string]interface{}{
+			"term": map[string]interface{}{
+				field: value,
+			},
+		})
+	}
+
+	if f.Match != "" {
+		filters = append(filters, map[string]interface{}{
+			"match_phrase": map[string]interface{}{
+				"message": f.Match,
+			},
+		})
+	}
+
+	return map[string]interface{}{
+		"bool": map[string]interface{}{
+			"filter": filters,
+		},
+	}
+}
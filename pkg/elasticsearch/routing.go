@@ -0,0 +1,85 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"time"
+)
+
+// Index patterns supported by IndexRouter.
+const (
+	IndexPatternHourly  = "hourly"
+	IndexPatternDaily   = "daily"
+	IndexPatternMonthly = "monthly"
+)
+
+// IndexRouter picks the dated index a log is written to from the log's own
+// timestamp, so backfilled logs age out with their own day. Timestamps that
+// are missing or further in the future than MaxFuture are routed by the
+// ingestion time instead so clock skew cannot create stray indices. Logs
+// older than MaxPast have no index to go to; see TooOld. A zero MaxFuture or
+// MaxPast disables the corresponding guard.
+type IndexRouter struct {
+	prefix    string
+	layout    string
+	location  *time.Location
+	maxFuture time.Duration
+	maxPast   time.Duration
+}
+
+func NewIndexRouter(prefix, pattern, timezone string, maxFuture, maxPast time.Duration) (*IndexRouter, error) {
+	var layout string
+	switch pattern {
+	case IndexPatternHourly:
+		layout = "2006.01.02.15"
+	case "", IndexPatternDaily:
+		layout = "2006.01.02"
+	case IndexPatternMonthly:
+		layout = "2006.01"
+	default:
+		return nil, fmt.Errorf("unknown index pattern: %s", pattern)
+	}
+
+	location := time.UTC
+	if timezone != "" {
+		var err error
+		location, err = time.LoadLocation(timezone)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &IndexRouter{
+		prefix:    prefix,
+		layout:    layout,
+		location:  location,
+		maxFuture: maxFuture,
+		maxPast:   maxPast,
+	}, nil
+}
+
+// IndexFor returns the index name for a log with the given timestamp.
+func (r *IndexRouter) IndexFor(timestamp time.Time) string {
+	return r.IndexWithPrefix(r.prefix, timestamp)
+}
+
+// IndexWithPrefix is like IndexFor but names the index after prefix instead
+// of the router's own prefix.
+func (r *IndexRouter) IndexWithPrefix(prefix string, timestamp time.Time) string {
+	now := time.Now()
+
+	switch {
+	case timestamp.IsZero():
+		timestamp = now
+	case r.maxFuture > 0 && timestamp.After(now.Add(r.maxFuture)):
+		timestamp = now
+	}
+
+	return fmt.Sprintf("%s-%s", prefix, timestamp.In(r.location).Format(r.layout))
+}
+
+// TooOld reports whether a log with the given timestamp is older than
+// MaxPast. Such logs are rejected rather than written to an index that has
+// already aged out or been deleted.
+func (r *IndexRouter) TooOld(timestamp time.Time) bool {
+	return r.maxPast > 0 && !timestamp.IsZero() && timestamp.Before(time.Now().Add(-r.maxPast))
+}
@@ -0,0 +1,196 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// LifecyclePolicy describes the ILM policy applied to the dated log indices.
+// Ages use Elasticsearch time units (e.g. "7d"); an empty age skips that
+// phase.
+type LifecyclePolicy struct {
+	Enabled     bool
+	WarmAfter   string
+	DeleteAfter string
+}
+
+// IndexSettings are applied to every dated log index through the template.
+// A zero Shards or nil Replicas keeps the cluster default. The template
+// covers the indices of the client's index and of each of its Prefixes.
+type IndexSettings struct {
+	Shards   int
+	Replicas *int
+}
+
+// InstallIndexTemplate installs the ILM policy (when enabled) and a composable
+// index template covering every dated log index, so they all share the log
+// mapping. Both are overwritten on every call, which makes it safe to run at
+// each startup.
+func (c *Client) InstallIndexTemplate(ctx context.Context, settings IndexSettings, policy LifecyclePolicy) error {
+	indexSettings := map[string]interface{}{}
+	if settings.Shards > 0 {
+		indexSettings["number_of_shards"] = settings.Shards
+	}
+	if settings.Replicas != nil {
+		indexSettings["number_of_replicas"] = *settings.Replicas
+	}
+
+	if policy.Enabled {
+		if err := c.putLifecyclePolicy(ctx, policy); err != nil {
+			return err
+		}
+		indexSettings["index.lifecycle.name"] = c.policyName()
+	}
+
+	template := map[string]interface{}{
+		"index_patterns": c.indexPatterns(),
+		"priority":       200,
+		"template": map[string]interface{}{
+			"settings": indexSettings,
+			"mappings": logMappings(),
+		},
+		"_meta": map[string]interface{}{
+			"managed_by": "log-ingestion",
+		},
+	}
+
+	body, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+
+	req := esapi.IndicesPutIndexTemplateRequest{
+		Name: fmt.Sprintf("%s-template", c.index),
+		Body: bytes.NewReader(body),
+	}
+
+	res, err := req.Do(ctx, c.es)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to install index template: %s", res.String())
+	}
+
+	return nil
+}
+
+func (c *Client) putLifecyclePolicy(ctx context.Context, policy LifecyclePolicy) error {
+	phases := map[string]interface{}{
+		"hot": map[string]interface{}{
+			"actions": map[string]interface{}{
+				"set_priority": map[string]interface{}{"priority": 100},
+			},
+		},
+	}
+
+	if policy.WarmAfter != "" {
+		phases["warm"] = map[string]interface{}{
+			"min_age": policy.WarmAfter,
+			"actions": map[string]interface{}{
+				"set_priority": map[string]interface{}{"priority": 50},
+				"forcemerge":   map[string]interface{}{"max_num_segments": 1},
+				"readonly":     map[string]interface{}{},
+			},
+		}
+	}
+
+	if policy.DeleteAfter != "" {
+		phases["delete"] = map[string]interface{}{
+			"min_age": policy.DeleteAfter,
+			"actions": map[string]interface{}{
+				"delete": map[string]interface{}{},
+			},
+		}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"policy": map[string]interface{}{
+			"phases": phases,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req := esapi.ILMPutLifecycleRequest{
+		Policy: c.policyName(),
+		Body:   bytes.NewReader(body),
+	}
+
+	res, err := req.Do(ctx, c.es)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to install lifecycle policy: %s", res.String())
+	}
+
+	return nil
+}
+
+// indexPatterns returns the index patterns for the client's index and every
+// configured prefix not already matched by it.
+func (c *Client) indexPatterns() []string {
+	patterns := []string{fmt.Sprintf("%s-*", c.index)}
+	seen := map[string]bool{c.index: true}
+	for _, prefix := range c.prefixes {
+		if prefix == "" || seen[prefix] || strings.HasPrefix(prefix, c.index+"-") {
+			continue
+		}
+		seen[prefix] = true
+		patterns = append(patterns, fmt.Sprintf("%s-*", prefix))
+	}
+	return patterns
+}
+
+func (c *Client) policyName() string {
+	return fmt.Sprintf("%s-policy", c.index)
+}
+
+func logMappings() map[string]interface{} {
+	return map[string]interface{}{
+		"dynamic_templates": []interface{}{
+			map[string]interface{}{
+				"tags_as_keywords": map[string]interface{}{
+					"path_match": "tags.*",
+					"mapping": map[string]interface{}{
+						"type": "keyword",
+					},
+				},
+			},
+		},
+		"properties": map[string]interface{}{
+			"timestamp": map[string]interface{}{
+				"type": "date",
+			},
+			"level": map[string]interface{}{
+				"type": "keyword",
+			},
+			"message": map[string]interface{}{
+				"type": "text",
+			},
+			"service": map[string]interface{}{
+				"type": "keyword",
+			},
+			"host": map[string]interface{}{
+				"type": "keyword",
+			},
+			"tags": map[string]interface{}{
+				"type": "object",
+			},
+			"fields": map[string]interface{}{
+				"type": "object",
+			},
+		},
+	}
+}
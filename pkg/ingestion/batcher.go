@@ -0,0 +1,311 @@
+package ingestion
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"awesomeProject6/internal/models"
+	"awesomeProject6/pkg/elasticsearch"
+	"awesomeProject6/pkg/kafka"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	initialRetryBackoff = 1 * time.Second
+	maxRetryBackoff     = 30 * time.Second
+	finalFlushTimeout   = 30 * time.Second
+)
+
+var (
+	batchDocuments = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "log_ingestion_batch_documents",
+		Help:    "Log entries per flushed batch.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+	})
+
+	batchBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "log_ingestion_batch_bytes",
+		Help:    "Estimated encoded size of each flushed batch.",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 9),
+	})
+
+	duplicates = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "log_ingestion_duplicates_total",
+		Help: "Documents skipped because a document with the same ID was already indexed.",
+	})
+
+	bulkDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "log_ingestion_bulk_duration_seconds",
+		Help:    "Latency of Elasticsearch bulk requests.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"result"})
+)
+
+// BatchConfig controls how log messages are grouped into bulk requests. A
+// batch is flushed when it holds BatchSize messages, when it reaches roughly
+// BatchBytes of encoded documents, or FlushInterval after it was started.
+// Workers bulk requests run concurrently. With DeterministicIDs, documents
+// are created with an ID derived from the entry's EventIDField or its Kafka
+// position, so a message that is indexed twice is only stored once.
+type BatchConfig struct {
+	BatchSize        int
+	BatchBytes       int
+	FlushInterval    time.Duration
+	Workers          int
+	DeterministicIDs bool
+	EventIDField     string
+}
+
+// BatchProcessor reads log messages, groups them into batches and indexes
+// them with a pool of bulk workers.
+type BatchProcessor struct {
+	esClient *elasticsearch.Client
+	dlq      *kafka.Producer
+	config   BatchConfig
+	logger   *logrus.Logger
+}
+
+// NewBatchProcessor creates a batch processor. Zero values in config fall
+// back to 100 messages, 5 MiB, 5 seconds and a single worker. dlq may be nil.
+func NewBatchProcessor(esClient *elasticsearch.Client, dlq *kafka.Producer, config BatchConfig) *BatchProcessor {
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.BatchBytes <= 0 {
+		config.BatchBytes = 5 * 1024 * 1024
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 5 * time.Second
+	}
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+
+	return &BatchProcessor{
+		esClient: esClient,
+		dlq:      dlq,
+		config:   config,
+		logger:   logrus.New(),
+	}
+}
+
+// Run batches messages from logChan until ctx is cancelled. Full batches are
+// handed to the workers over an unbuffered channel, so when every worker is
+// busy the batcher stops reading and logChan fills up, pushing back on the
+// producers.
+func (p *BatchProcessor) Run(ctx context.Context, logChan <-chan kafka.LogMessage) {
+	batches := make(chan []kafka.LogMessage)
+
+	var wg sync.WaitGroup
+	for i := 0; i < p.config.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				p.indexWithRetry(ctx, batch)
+			}
+		}()
+	}
+
+	p.batch(ctx, logChan, batches)
+	close(batches)
+	wg.Wait()
+}
+
+func (p *BatchProcessor) batch(ctx context.Context, logChan <-chan kafka.LogMessage, batches chan<- []kafka.LogMessage) {
+	batch := make([]kafka.LogMessage, 0, p.config.BatchSize)
+	size := 0
+	ticker := time.NewTicker(p.config.FlushInterval)
+	defer ticker.Stop()
+
+	flush := func() bool {
+		select {
+		case batches <- batch:
+		case <-ctx.Done():
+			return false
+		}
+		batchDocuments.Observe(float64(len(batch)))
+		batchBytes.Observe(float64(size))
+		batch = make([]kafka.LogMessage, 0, p.config.BatchSize)
+		size = 0
+		return true
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if len(batch) > 0 {
+				batchDocuments.Observe(float64(len(batch)))
+				batchBytes.Observe(float64(size))
+				flushCtx, cancel := context.WithTimeout(context.Background(), finalFlushTimeout)
+				if _, err := p.indexBatch(flushCtx, batch); err != nil {
+					p.logger.Errorf("Failed to index final batch, it will be redelivered: %v", err)
+				}
+				cancel()
+			}
+			return
+
+		case msg := <-logChan:
+			// Send the messages of revoked partitions on right away so the
+			// consumer can commit them before the partitions move to
+			// another member.
+			if revoked := msg.Revoked(); revoked != nil {
+				flushing, kept := splitRevoked(batch, revoked)
+				if len(flushing) == 0 {
+					continue
+				}
+				batch, size = flushing, 0
+				for _, msg := range flushing {
+					size += estimateSize(msg.Entry)
+				}
+				// If the flush is cut short by shutdown, the revoked
+				// messages stay in the batch for the final flush along
+				// with the kept ones.
+				flush()
+				for _, msg := range kept {
+					batch = append(batch, msg)
+					size += estimateSize(msg.Entry)
+				}
+				continue
+			}
+
+			batch = append(batch, msg)
+			size += estimateSize(msg.Entry)
+			if len(batch) >= p.config.BatchSize || size >= p.config.BatchBytes {
+				if !flush() {
+					continue
+				}
+			}
+
+		case <-ticker.C:
+			if len(batch) > 0 {
+				flush()
+			}
+		}
+	}
+}
+
+// indexWithRetry indexes batch, retrying the documents that were not stored
+// with exponential backoff until all succeed or ctx is cancelled. Unindexed
+// messages are never acked and are redelivered after a restart.
+func (p *BatchProcessor) indexWithRetry(ctx context.Context, batch []kafka.LogMessage) {
+	backoff := initialRetryBackoff
+
+	for {
+		remaining, err := p.indexBatch(ctx, batch)
+		if err == nil {
+			return
+		}
+
+		p.logger.Errorf("Failed to index %d of %d logs, retrying in %s: %v", len(remaining), len(batch), backoff, err)
+		batch = remaining
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// indexBatch bulk indexes batch and acks every message that was stored.
+// Documents Elasticsearch permanently rejected are sent to the dead-letter
+// topic and acked, or dropped when none is configured. The messages that
+// still need to be retried are returned along with an error.
+func (p *BatchProcessor) indexBatch(ctx context.Context, batch []kafka.LogMessage) ([]kafka.LogMessage, error) {
+	docs := make([]elasticsearch.BulkDocument, len(batch))
+	for i, msg := range batch {
+		docs[i] = elasticsearch.BulkDocument{Entry: msg.Entry, Index: msg.Index}
+		if p.config.DeterministicIDs {
+			docs[i].ID = documentID(msg, p.config.EventIDField)
+		}
+	}
+
+	start := time.Now()
+	report, err := p.esClient.BulkIndex(ctx, docs)
+	result := "success"
+	if err != nil {
+		result = "error"
+	} else if len(report.Failed) > 0 {
+		result = "partial"
+	}
+	bulkDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	duplicates.Add(float64(report.Duplicates))
+
+	failures := make(map[int]elasticsearch.BulkFailure, len(report.Failed))
+	for _, failure := range report.Failed {
+		failures[failure.Position] = failure
+	}
+
+	var remaining []kafka.LogMessage
+	for i, msg := range batch {
+		failure, failed := failures[i]
+		switch {
+		case !failed:
+			msg.Ack()
+		case failure.Retryable:
+			remaining = append(remaining, msg)
+		case p.dlq == nil:
+			p.logger.WithFields(logrus.Fields{
+				"status": failure.Status,
+				"type":   failure.Type,
+				"log":    msg.Entry,
+			}).Errorf("Dropping log rejected by Elasticsearch: %s", failure.Reason)
+			msg.Ack()
+		default:
+			cause := fmt.Sprintf("%d %s: %s", failure.Status, failure.Type, failure.Reason)
+			if err := kafka.DeadLetterLog(p.dlq, msg, cause); err != nil {
+				p.logger.Errorf("Failed to forward rejected log to dead-letter topic: %v", err)
+				remaining = append(remaining, msg)
+				continue
+			}
+			msg.Ack()
+		}
+	}
+
+	if err == nil && len(remaining) > 0 {
+		err = fmt.Errorf("%d documents were not indexed", len(remaining))
+	}
+	return remaining, err
+}
+
+// splitRevoked separates the messages of the revoked partitions from the
+// rest of batch.
+func splitRevoked(batch []kafka.LogMessage, revoked []kafka.Partition) ([]kafka.LogMessage, []kafka.LogMessage) {
+	var flushing, kept []kafka.LogMessage
+	for _, msg := range batch {
+		if msg.BelongsTo(revoked) {
+			flushing = append(flushing, msg)
+		} else {
+			kept = append(kept, msg)
+		}
+	}
+	return flushing, kept
+}
+
+// estimateSize approximates the encoded size of a log entry without
+// marshalling it.
+func estimateSize(entry models.LogEntry) int {
+	size := 128 + len(entry.Level) + len(entry.Message) + len(entry.Service) + len(entry.Host)
+	for k, v := range entry.Tags {
+		size += len(k) + len(v) + 6
+	}
+	for k, v := range entry.Fields {
+		size += len(k) + 6
+		if s, ok := v.(string); ok {
+			size += len(s)
+		} else {
+			size += 16
+		}
+	}
+	return size
+}
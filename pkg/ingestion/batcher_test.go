@@ -0,0 +1,242 @@
+package ingestion
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"awesomeProject6/internal/models"
+	"awesomeProject6/pkg/elasticsearch"
+	"awesomeProject6/pkg/kafka"
+)
+
+// bulkLatency is how long the fake Elasticsearch takes to answer a bulk
+// request, so the benchmark shows how workers overlap requests.
+const bulkLatency = 5 * time.Millisecond
+
+// BenchmarkBatchProcessor measures how ingestion throughput scales with the
+// number of bulk workers against a fake Elasticsearch with a fixed latency.
+func BenchmarkBatchProcessor(b *testing.B) {
+	server := httptest.NewServer(fakeBulkHandler(bulkLatency))
+	defer server.Close()
+
+	esClient, err := elasticsearch.NewClient(elasticsearch.ClientConfig{
+		URLs:  []string{server.URL},
+		Index: "bench-logs",
+	})
+	if err != nil {
+		b.Fatalf("NewClient: %v", err)
+	}
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			processor := NewBatchProcessor(esClient, nil, BatchConfig{
+				BatchSize:     500,
+				FlushInterval: 100 * time.Millisecond,
+				Workers:       workers,
+			})
+			processor.logger.SetOutput(&bytes.Buffer{})
+
+			logChan := make(chan kafka.LogMessage, 1000)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				processor.Run(ctx, logChan)
+				close(done)
+			}()
+
+			var acked sync.WaitGroup
+			acked.Add(b.N)
+
+			now := time.Now()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				entry := models.LogEntry{
+					Timestamp: now,
+					Level:     "INFO",
+					Message:   fmt.Sprintf("benchmark log message %d", i),
+					Service:   "bench",
+					Host:      "localhost",
+					Tags:      map[string]string{"env": "bench"},
+				}
+				logChan <- kafka.NewLogMessage(entry, acked.Done)
+			}
+			acked.Wait()
+			b.StopTimer()
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "logs/s")
+
+			cancel()
+			<-done
+		})
+	}
+}
+
+// ackRecorder records which test messages have been acked.
+type ackRecorder struct {
+	mutex sync.Mutex
+	acked map[string]bool
+}
+
+func newAckRecorder() *ackRecorder {
+	return &ackRecorder{acked: make(map[string]bool)}
+}
+
+// message returns a message from the given partition of topic "logs" that
+// records its ack under text.
+func (r *ackRecorder) message(text string, partition int32) kafka.LogMessage {
+	msg := kafka.NewLogMessage(models.LogEntry{Timestamp: time.Now(), Message: text}, func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.acked[text] = true
+	})
+	msg.Topic = "logs"
+	msg.Partition = partition
+	return msg
+}
+
+func (r *ackRecorder) isAcked(text string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.acked[text]
+}
+
+// waitAcked reports whether every text was acked within wait.
+func (r *ackRecorder) waitAcked(wait time.Duration, texts ...string) bool {
+	deadline := time.Now().Add(wait)
+	for {
+		all := true
+		for _, text := range texts {
+			all = all && r.isAcked(text)
+		}
+		if all {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newTestBatchProcessor(t *testing.T, config BatchConfig) *BatchProcessor {
+	t.Helper()
+
+	server := httptest.NewServer(fakeBulkHandler(0))
+	t.Cleanup(server.Close)
+
+	esClient, err := elasticsearch.NewClient(elasticsearch.ClientConfig{
+		URLs:  []string{server.URL},
+		Index: "test-logs",
+	})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	processor := NewBatchProcessor(esClient, nil, config)
+	processor.logger.SetOutput(&bytes.Buffer{})
+	return processor
+}
+
+func TestBatchProcessorFlushesRevokedPartitions(t *testing.T) {
+	processor := newTestBatchProcessor(t, BatchConfig{BatchSize: 100, FlushInterval: time.Minute})
+	acks := newAckRecorder()
+
+	logChan := make(chan kafka.LogMessage)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		processor.Run(ctx, logChan)
+		close(done)
+	}()
+
+	logChan <- acks.message("p0-a", 0)
+	logChan <- acks.message("p1-a", 1)
+	logChan <- acks.message("p0-b", 0)
+	logChan <- acks.message("p1-b", 1)
+	logChan <- kafka.NewFlushMarker([]kafka.Partition{{Topic: "logs", Partition: 0}})
+
+	// The consumer's Cleanup waits for exactly these acks.
+	if !acks.waitAcked(time.Second, "p0-a", "p0-b") {
+		t.Fatal("messages of the revoked partition were not flushed")
+	}
+	if acks.isAcked("p1-a") || acks.isAcked("p1-b") {
+		t.Error("messages of the partition that was kept were flushed with the revoked one")
+	}
+
+	// The kept messages stay batched and are flushed with the rest.
+	logChan <- acks.message("p1-c", 1)
+	cancel()
+	<-done
+	if !acks.waitAcked(0, "p1-a", "p1-b", "p1-c") {
+		t.Error("kept messages were not indexed by the final flush")
+	}
+}
+
+func TestBatchProcessorKeepsMessagesWhenRevokedFlushIsCut(t *testing.T) {
+	processor := newTestBatchProcessor(t, BatchConfig{BatchSize: 100, FlushInterval: time.Minute})
+	acks := newAckRecorder()
+
+	// Nothing takes batches, as when every worker is busy, so the flush for
+	// the revoked partition blocks until shutdown.
+	batches := make(chan []kafka.LogMessage)
+	logChan := make(chan kafka.LogMessage)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		processor.batch(ctx, logChan, batches)
+		close(done)
+	}()
+
+	logChan <- acks.message("p0-a", 0)
+	logChan <- acks.message("p1-a", 1)
+	logChan <- kafka.NewFlushMarker([]kafka.Partition{{Topic: "logs", Partition: 0}})
+	cancel()
+	<-done
+
+	if !acks.waitAcked(0, "p0-a", "p1-a") {
+		t.Errorf("final flush acked p0-a=%v p1-a=%v, want both", acks.isAcked("p0-a"), acks.isAcked("p1-a"))
+	}
+}
+
+// fakeBulkHandler answers every bulk request with one successful item per
+// document after the given latency.
+func fakeBulkHandler(latency time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+
+		if !strings.HasSuffix(r.URL.Path, "/_bulk") {
+			w.Write([]byte(`{}`))
+			return
+		}
+
+		lines := 0
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+		for scanner.Scan() {
+			if len(bytes.TrimSpace(scanner.Bytes())) > 0 {
+				lines++
+			}
+		}
+
+		time.Sleep(latency)
+
+		var buf bytes.Buffer
+		buf.WriteString(`{"took":1,"errors":false,"items":[`)
+		for i := 0; i < lines/2; i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(`{"index":{"status":201}}`)
+		}
+		buf.WriteString(`]}`)
+		w.Write(buf.Bytes())
+	}
+}
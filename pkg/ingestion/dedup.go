@@ -0,0 +1,39 @@
+package ingestion
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"awesomeProject6/pkg/kafka"
+	"awesomeProject6/pkg/processor"
+)
+
+// maxDocumentID is the longest _id Elasticsearch accepts, in bytes.
+const maxDocumentID = 512
+
+// documentID returns a deterministic ID for msg so that indexing it again,
+// after a redelivery or a replay, does not create a duplicate. A
+// producer-supplied event ID at eventIDField is used when present, so retries
+// by the producer are caught too; otherwise the ID is a hash of the Kafka
+// position the message was consumed from. Messages with neither get no ID.
+func documentID(msg kafka.LogMessage, eventIDField string) string {
+	if eventIDField != "" {
+		if id, ok := processor.Get(&msg.Entry, eventIDField); ok && id != "" {
+			if len(id) > maxDocumentID {
+				return hashID(id)
+			}
+			return id
+		}
+	}
+
+	if msg.Topic == "" {
+		return ""
+	}
+	return hashID(fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset))
+}
+
+func hashID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,30 @@
+package ingestion
+
+import (
+	"awesomeProject6/pkg/kafka"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ObserveBuffer exports the occupancy and capacity of a channel between two
+// pipeline stages, labelled with name. A buffer that stays full points at
+// the stage reading from it as the bottleneck.
+func ObserveBuffer(name string, buffer chan kafka.LogMessage) {
+	labels := prometheus.Labels{"buffer": name}
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "log_ingestion_buffer_messages",
+		Help:        "Messages waiting in a pipeline buffer.",
+		ConstLabels: labels,
+	}, func() float64 {
+		return float64(len(buffer))
+	})
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "log_ingestion_buffer_capacity",
+		Help:        "Capacity of a pipeline buffer.",
+		ConstLabels: labels,
+	}, func() float64 {
+		return float64(cap(buffer))
+	})
+}
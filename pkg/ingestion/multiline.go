@@ -0,0 +1,224 @@
+package ingestion
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"awesomeProject6/pkg/kafka"
+)
+
+// MultilineConfig configures a Multiline combiner. A line continues the
+// entry before it from the same source (Kafka partition, service and host)
+// when it matches ContinuationPattern, or when StartPattern is set and it
+// does not match it. An entry is emitted when the next one starts, when it
+// reaches MaxLines, when the next line would take its message past MaxBytes,
+// or when no continuation arrived for FlushTimeout.
+type MultilineConfig struct {
+	StartPattern        string
+	ContinuationPattern string
+	FlushTimeout        time.Duration
+	MaxLines            int
+	MaxBytes            int
+}
+
+// Multiline joins log entries that are really one event spread across
+// several messages, such as stack traces, into a single entry.
+type Multiline struct {
+	start        *regexp.Regexp
+	continuation *regexp.Regexp
+	flushTimeout time.Duration
+	maxLines     int
+	maxBytes     int
+	pending      map[string]*pendingEntry
+}
+
+// pendingEntry is an entry that may still receive continuation lines.
+type pendingEntry struct {
+	parts   []kafka.LogMessage
+	lines   []string
+	size    int
+	updated time.Time
+}
+
+// NewMultiline creates a combiner. At least one pattern is required. Zero
+// values fall back to a 2 second flush timeout, 500 lines and 1 MiB.
+func NewMultiline(config MultilineConfig) (*Multiline, error) {
+	if config.StartPattern == "" && config.ContinuationPattern == "" {
+		return nil, fmt.Errorf("multiline requires start_pattern or continuation_pattern")
+	}
+	if config.FlushTimeout <= 0 {
+		config.FlushTimeout = 2 * time.Second
+	}
+	if config.MaxLines <= 0 {
+		config.MaxLines = 500
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = 1024 * 1024
+	}
+
+	m := &Multiline{
+		flushTimeout: config.FlushTimeout,
+		maxLines:     config.MaxLines,
+		maxBytes:     config.MaxBytes,
+		pending:      make(map[string]*pendingEntry),
+	}
+
+	var err error
+	if config.StartPattern != "" {
+		if m.start, err = regexp.Compile(config.StartPattern); err != nil {
+			return nil, fmt.Errorf("invalid start_pattern: %v", err)
+		}
+	}
+	if config.ContinuationPattern != "" {
+		if m.continuation, err = regexp.Compile(config.ContinuationPattern); err != nil {
+			return nil, fmt.Errorf("invalid continuation_pattern: %v", err)
+		}
+	}
+
+	return m, nil
+}
+
+// Run combines messages from in and sends the assembled entries to out until
+// ctx is cancelled. An assembled entry acks every message it was built from.
+// Entries still pending at shutdown are left unacked and redelivered.
+func (m *Multiline) Run(ctx context.Context, in <-chan kafka.LogMessage, out chan<- kafka.LogMessage) {
+	ticker := time.NewTicker(m.flushTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case msg := <-in:
+			var ready []kafka.LogMessage
+			if revoked := msg.Revoked(); revoked != nil {
+				ready = append(m.revoke(revoked), msg)
+			} else {
+				ready = m.add(msg, time.Now())
+			}
+			for _, next := range ready {
+				if !send(ctx, out, next) {
+					return
+				}
+			}
+
+		case now := <-ticker.C:
+			for _, ready := range m.expire(now) {
+				if !send(ctx, out, ready) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// add feeds one message to the combiner and returns the entries it
+// completed.
+func (m *Multiline) add(msg kafka.LogMessage, now time.Time) []kafka.LogMessage {
+	key := sourceKey(msg)
+	pending, ok := m.pending[key]
+	line := msg.Entry.Message
+
+	var ready []kafka.LogMessage
+	if ok && m.continues(line) && pending.size+1+len(line) <= m.maxBytes {
+		pending.parts = append(pending.parts, msg)
+		pending.lines = append(pending.lines, line)
+		pending.size += 1 + len(line)
+		pending.updated = now
+		if len(pending.lines) >= m.maxLines {
+			ready = append(ready, pending.combine())
+			delete(m.pending, key)
+		}
+		return ready
+	}
+
+	if ok {
+		ready = append(ready, pending.combine())
+	}
+	m.pending[key] = &pendingEntry{
+		parts:   []kafka.LogMessage{msg},
+		lines:   []string{line},
+		size:    len(line),
+		updated: now,
+	}
+	return ready
+}
+
+// sourceKey identifies where a line came from, so lines of one source are
+// never joined with another's even when they share a service and host.
+func sourceKey(msg kafka.LogMessage) string {
+	return fmt.Sprintf("%s\x00%d\x00%s\x00%s", msg.Topic, msg.Partition, msg.Entry.Service, msg.Entry.Host)
+}
+
+// expire returns the entries that have not been continued within the flush
+// timeout.
+func (m *Multiline) expire(now time.Time) []kafka.LogMessage {
+	var ready []kafka.LogMessage
+	for key, pending := range m.pending {
+		if now.Sub(pending.updated) >= m.flushTimeout {
+			ready = append(ready, pending.combine())
+			delete(m.pending, key)
+		}
+	}
+	return ready
+}
+
+// revoke returns the pending entries holding lines from the revoked
+// partitions.
+func (m *Multiline) revoke(revoked []kafka.Partition) []kafka.LogMessage {
+	var ready []kafka.LogMessage
+	for key, pending := range m.pending {
+		for _, part := range pending.parts {
+			if part.BelongsTo(revoked) {
+				ready = append(ready, pending.combine())
+				delete(m.pending, key)
+				break
+			}
+		}
+	}
+	return ready
+}
+
+func (m *Multiline) continues(line string) bool {
+	if m.continuation != nil && m.continuation.MatchString(line) {
+		return true
+	}
+	return m.start != nil && !m.start.MatchString(line)
+}
+
+// combine builds one message from the pending parts. It keeps the first
+// part's attributes and Kafka position and acks all parts together.
+func (p *pendingEntry) combine() kafka.LogMessage {
+	if len(p.parts) == 1 {
+		return p.parts[0]
+	}
+
+	first := p.parts[0]
+	entry := first.Entry
+	entry.Message = strings.Join(p.lines, "\n")
+
+	parts := p.parts
+	msg := kafka.NewLogMessage(entry, func() {
+		for _, part := range parts {
+			part.Ack()
+		}
+	})
+	msg.Topic = first.Topic
+	msg.Partition = first.Partition
+	msg.Offset = first.Offset
+	msg.Index = first.Index
+	return msg
+}
+
+func send(ctx context.Context, out chan<- kafka.LogMessage, msg kafka.LogMessage) bool {
+	select {
+	case out <- msg:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,223 @@
+package ingestion
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"awesomeProject6/internal/models"
+	"awesomeProject6/pkg/kafka"
+)
+
+// multilineInput is a message fed to the combiner at the given offset from
+// the start of the test.
+type multilineInput struct {
+	text      string
+	partition int32
+	service   string
+	at        time.Duration
+}
+
+func (in multilineInput) message(ack func()) kafka.LogMessage {
+	service := in.service
+	if service == "" {
+		service = "api"
+	}
+	msg := kafka.NewLogMessage(models.LogEntry{Message: in.text, Service: service, Host: "web01"}, ack)
+	msg.Topic = "logs"
+	msg.Partition = in.partition
+	return msg
+}
+
+func TestMultiline(t *testing.T) {
+	stackTrace := []multilineInput{
+		{text: "ERROR request failed"},
+		{text: "java.lang.IllegalStateException: boom"},
+		{text: "\tat com.example.Handler.run(Handler.java:42)"},
+		{text: "Caused by: java.io.IOException: closed"},
+		{text: "\tat com.example.Conn.read(Conn.java:7)"},
+		{text: "INFO next request"},
+	}
+
+	tests := []struct {
+		name   string
+		config MultilineConfig
+		inputs []multilineInput
+		// want are the entries completed while adding, in order; pending
+		// are those only emitted by the flush timeout, sorted.
+		want    []string
+		pending []string
+	}{
+		{
+			name:   "continuation pattern",
+			config: MultilineConfig{ContinuationPattern: `^(\s|Caused by: )`},
+			inputs: stackTrace,
+			want: []string{
+				"ERROR request failed",
+				"java.lang.IllegalStateException: boom\n\tat com.example.Handler.run(Handler.java:42)\nCaused by: java.io.IOException: closed\n\tat com.example.Conn.read(Conn.java:7)",
+			},
+			pending: []string{"INFO next request"},
+		},
+		{
+			name:   "start pattern",
+			config: MultilineConfig{StartPattern: `^(ERROR|INFO) `},
+			inputs: stackTrace,
+			want: []string{
+				"ERROR request failed\njava.lang.IllegalStateException: boom\n\tat com.example.Handler.run(Handler.java:42)\nCaused by: java.io.IOException: closed\n\tat com.example.Conn.read(Conn.java:7)",
+			},
+			pending: []string{"INFO next request"},
+		},
+		{
+			name:   "max lines",
+			config: MultilineConfig{StartPattern: `^(ERROR|INFO) `, MaxLines: 2},
+			inputs: stackTrace,
+			want: []string{
+				"ERROR request failed\njava.lang.IllegalStateException: boom",
+				"\tat com.example.Handler.run(Handler.java:42)\nCaused by: java.io.IOException: closed",
+				"\tat com.example.Conn.read(Conn.java:7)",
+			},
+			pending: []string{"INFO next request"},
+		},
+		{
+			name:   "max bytes",
+			config: MultilineConfig{ContinuationPattern: `^\s`, MaxBytes: 12},
+			inputs: []multilineInput{
+				{text: "panic: x"},
+				{text: " a"},
+				{text: " b"},
+				{text: " c"},
+				{text: " d"},
+			},
+			want:    []string{"panic: x\n a"},
+			pending: []string{" b\n c\n d"},
+		},
+		{
+			name:   "flush timeout",
+			config: MultilineConfig{ContinuationPattern: `^\s`, FlushTimeout: time.Second},
+			inputs: []multilineInput{
+				{text: "panic: x"},
+				{text: " first frame", at: 500 * time.Millisecond},
+				// Arrives after the entry has been flushed, so it starts
+				// an entry of its own.
+				{text: " late frame", at: 3 * time.Second},
+			},
+			want:    []string{"panic: x\n first frame"},
+			pending: []string{" late frame"},
+		},
+		{
+			name:   "per partition",
+			config: MultilineConfig{ContinuationPattern: `^\s`},
+			inputs: []multilineInput{
+				{text: "panic: one", partition: 0},
+				{text: "panic: two", partition: 1},
+				{text: " frame of two", partition: 1},
+				{text: " frame of one", partition: 0},
+			},
+			pending: []string{"panic: one\n frame of one", "panic: two\n frame of two"},
+		},
+		{
+			name:   "per service",
+			config: MultilineConfig{ContinuationPattern: `^\s`},
+			inputs: []multilineInput{
+				{text: "panic: api", service: "api"},
+				{text: "panic: worker", service: "worker"},
+				{text: " frame of worker", service: "worker"},
+				{text: " frame of api", service: "api"},
+				{text: "done", service: "api"},
+			},
+			want:    []string{"panic: api\n frame of api"},
+			pending: []string{"done", "panic: worker\n frame of worker"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewMultiline(tt.config)
+			if err != nil {
+				t.Fatalf("NewMultiline: %v", err)
+			}
+
+			start := time.Now()
+			var got []string
+			for _, in := range tt.inputs {
+				now := start.Add(in.at)
+				for _, ready := range m.expire(now) {
+					got = append(got, ready.Entry.Message)
+				}
+				for _, ready := range m.add(in.message(nil), now) {
+					got = append(got, ready.Entry.Message)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("emitted %q, want %q", got, tt.want)
+			}
+
+			last := start
+			if n := len(tt.inputs); n > 0 {
+				last = start.Add(tt.inputs[n-1].at)
+			}
+			if ready := m.expire(last.Add(m.flushTimeout - time.Millisecond)); len(ready) != 0 {
+				t.Errorf("flushed %d entries before the timeout", len(ready))
+			}
+			var pending []string
+			for _, ready := range m.expire(last.Add(m.flushTimeout)) {
+				pending = append(pending, ready.Entry.Message)
+			}
+			sort.Strings(pending)
+			if !reflect.DeepEqual(pending, tt.pending) {
+				t.Errorf("flushed %q after the timeout, want %q", pending, tt.pending)
+			}
+		})
+	}
+}
+
+func TestMultilineAcksEveryPart(t *testing.T) {
+	m, err := NewMultiline(MultilineConfig{ContinuationPattern: `^\s`})
+	if err != nil {
+		t.Fatalf("NewMultiline: %v", err)
+	}
+
+	acks := make([]int, 4)
+	now := time.Now()
+	var ready []kafka.LogMessage
+	for i, text := range []string{"panic: x", " a", " b", "next"} {
+		i := i
+		ready = append(ready, m.add(multilineInput{text: text}.message(func() { acks[i]++ }), now)...)
+	}
+	if len(ready) != 1 {
+		t.Fatalf("emitted %d entries, want 1", len(ready))
+	}
+
+	ready[0].Ack()
+	if want := []int{1, 1, 1, 0}; !reflect.DeepEqual(acks, want) {
+		t.Errorf("acks per message = %v, want %v", acks, want)
+	}
+}
+
+func TestMultilineRunFlushesAfterTimeout(t *testing.T) {
+	m, err := NewMultiline(MultilineConfig{ContinuationPattern: `^\s`, FlushTimeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewMultiline: %v", err)
+	}
+
+	in := make(chan kafka.LogMessage)
+	out := make(chan kafka.LogMessage)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx, in, out)
+
+	in <- multilineInput{text: "panic: x"}.message(nil)
+	in <- multilineInput{text: " frame"}.message(nil)
+
+	select {
+	case msg := <-out:
+		if lines := strings.Split(msg.Entry.Message, "\n"); len(lines) != 2 {
+			t.Errorf("flushed %q, want both lines", msg.Entry.Message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending entry was not flushed after the timeout")
+	}
+}
@@ -0,0 +1,37 @@
+package ingestion
+
+import (
+	"context"
+
+	"awesomeProject6/pkg/kafka"
+	"awesomeProject6/pkg/processor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var processorDropped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "log_ingestion_processor_dropped_total",
+	Help: "Log entries dropped by the processor chain.",
+})
+
+// RunProcessors applies chain to every message from in and forwards the ones
+// it keeps to out until ctx is cancelled. Dropped messages are acked right
+// away since there is nothing left to store.
+func RunProcessors(ctx context.Context, chain processor.Chain, in <-chan kafka.LogMessage, out chan<- kafka.LogMessage) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-in:
+			if msg.Revoked() == nil && !chain.Process(&msg.Entry) {
+				processorDropped.Inc()
+				msg.Ack()
+				continue
+			}
+
+			if !send(ctx, out, msg) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package ingestion
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"awesomeProject6/internal/models"
+)
+
+// Spool segments are append-only files named after the sequence number of
+// their first record. Each record is a 4 byte big-endian payload length, a 4
+// byte CRC-32 of the payload and the JSON encoded spoolRecord.
+const (
+	segmentSuffix    = ".seg"
+	recordHeaderSize = 8
+	maxRecordSize    = 64 * 1024 * 1024
+)
+
+var errCorruptRecord = errors.New("corrupt spool record")
+
+// spoolRecord is a log message as stored in the spool, keeping the Kafka
+// position it was consumed from for dead-lettering and its target index.
+type spoolRecord struct {
+	Entry     models.LogEntry `json:"entry"`
+	Topic     string          `json:"topic,omitempty"`
+	Partition int32           `json:"partition"`
+	Offset    int64           `json:"offset"`
+	Index     string          `json:"index,omitempty"`
+	SpooledAt time.Time       `json:"spooled_at"`
+}
+
+type segment struct {
+	start uint64
+	size  int64
+	path  string
+}
+
+func segmentPath(dir string, start uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", start, segmentSuffix))
+}
+
+// listSegments returns the segments in dir ordered by their first sequence
+// number.
+func listSegments(dir string) ([]segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []segment
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		start, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment{
+			start: start,
+			size:  info.Size(),
+			path:  filepath.Join(dir, name),
+		})
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].start < segments[j].start
+	})
+	return segments, nil
+}
+
+func encodeRecord(record spoolRecord) ([]byte, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(data[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(data[4:8], crc32.ChecksumIEEE(payload))
+	copy(data[recordHeaderSize:], payload)
+	return data, nil
+}
+
+// readRecord reads the next record from r. It returns io.EOF at a clean end of
+// the segment and errCorruptRecord for a torn or damaged record.
+func readRecord(r *bufio.Reader) (spoolRecord, int, error) {
+	var record spoolRecord
+
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return record, 0, errCorruptRecord
+		}
+		return record, 0, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxRecordSize {
+		return record, 0, errCorruptRecord
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return record, 0, errCorruptRecord
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return record, 0, errCorruptRecord
+	}
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return record, 0, errCorruptRecord
+	}
+
+	return record, recordHeaderSize + int(length), nil
+}
+
+// recoverSegment counts the complete records in the segment at path and
+// truncates anything after them, such as a record torn by a crash.
+func recoverSegment(path string) (uint64, int64, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	var count uint64
+	var valid int64
+	reader := bufio.NewReader(file)
+	for {
+		_, n, err := readRecord(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if err := file.Truncate(valid); err != nil {
+				return 0, 0, err
+			}
+			break
+		}
+		count++
+		valid += int64(n)
+	}
+
+	return count, valid, nil
+}
+
+// syncDir makes file creations and removals in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,701 @@
+package ingestion
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"awesomeProject6/pkg/kafka"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	checkpointFile      = "checkpoint"
+	spoolWriteBatch     = 1000
+	spoolMaintainPeriod = 1 * time.Second
+)
+
+var (
+	spoolRecords = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "log_ingestion_spool_records",
+		Help: "Records in the spool that have not been indexed yet.",
+	})
+
+	spoolBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "log_ingestion_spool_bytes",
+		Help: "Disk space used by spool segments.",
+	})
+
+	spoolSegments = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "log_ingestion_spool_segments",
+		Help: "Number of spool segment files on disk.",
+	})
+
+	spoolOldestAge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "log_ingestion_spool_oldest_record_age_seconds",
+		Help: "Time since the oldest record still in the spool was written.",
+	})
+
+	spoolFull = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "log_ingestion_spool_full_total",
+		Help: "Times the spool reached its disk quota and stopped accepting messages.",
+	})
+)
+
+// SpoolConfig configures a Spool. MaxBytes is the disk quota for all
+// segments; once it is reached the spool stops reading new messages until
+// indexed ones free up space. Segments are rolled at SegmentBytes.
+type SpoolConfig struct {
+	Dir          string
+	MaxBytes     int64
+	SegmentBytes int64
+}
+
+// Spool is a durable on-disk queue between the Kafka consumer and the batch
+// processor. Messages are acked to Kafka as soon as they are synced to a
+// segment, so an Elasticsearch outage fills the spool instead of stalling the
+// consumer. Records are handed on in the order they were written and a
+// segment is deleted once every record in it has been acked.
+//
+// Records are numbered with a sequence that continues across segments. The
+// checkpoint file holds the sequence below which every record was acked;
+// after a restart, delivery resumes from there.
+type Spool struct {
+	config SpoolConfig
+
+	mutex         sync.Mutex
+	segments      []segment
+	writer        *os.File
+	written       uint64
+	acked         uint64
+	checkpointed  uint64
+	pending       map[uint64]bool
+	spooled       []spooledRange
+	usage         int64
+	rollRequested bool
+
+	dataReady  chan struct{}
+	spaceReady chan struct{}
+	logger     *logrus.Logger
+}
+
+// OpenSpool opens the spool in config.Dir, creating it if needed, and
+// recovers its state from the segments and checkpoint on disk. Zero values
+// fall back to the "spool" directory, a 1 GiB quota and 64 MiB segments.
+func OpenSpool(config SpoolConfig) (*Spool, error) {
+	if config.Dir == "" {
+		config.Dir = "spool"
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = 1024 * 1024 * 1024
+	}
+	if config.SegmentBytes <= 0 {
+		config.SegmentBytes = 64 * 1024 * 1024
+	}
+	// Keep a few segments within the quota so fully acked ones can be
+	// deleted while the current one is still being written.
+	if config.SegmentBytes > config.MaxBytes/4 {
+		config.SegmentBytes = config.MaxBytes / 4
+	}
+
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &Spool{
+		config:     config,
+		pending:    make(map[uint64]bool),
+		dataReady:  make(chan struct{}, 1),
+		spaceReady: make(chan struct{}, 1),
+		logger:     logrus.New(),
+	}
+
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+
+	s.logger.Infof("Opened spool in %s with %d pending records (%d bytes)", config.Dir, s.written-s.acked, s.usage)
+	s.updateMetrics()
+	return s, nil
+}
+
+func (s *Spool) recover() error {
+	checkpoint, err := readCheckpoint(s.config.Dir)
+	if err != nil {
+		return err
+	}
+
+	segments, err := listSegments(s.config.Dir)
+	if err != nil {
+		return err
+	}
+
+	// Drop segments that were fully acked before they could be deleted.
+	for len(segments) > 1 && segments[1].start <= checkpoint {
+		if err := os.Remove(segments[0].path); err != nil {
+			return err
+		}
+		segments = segments[1:]
+	}
+
+	if len(segments) == 0 {
+		s.written = checkpoint
+		s.acked = checkpoint
+		s.checkpointed = checkpoint
+		return s.createSegment(checkpoint)
+	}
+
+	last := &segments[len(segments)-1]
+	count, size, err := recoverSegment(last.path)
+	if err != nil {
+		return err
+	}
+	last.size = size
+
+	s.segments = segments
+	s.written = last.start + count
+	s.acked = checkpoint
+	if s.acked < segments[0].start {
+		s.acked = segments[0].start
+	}
+	if s.acked > s.written {
+		s.acked = s.written
+	}
+	s.checkpointed = s.acked
+
+	for _, seg := range segments {
+		s.usage += seg.size
+	}
+
+	s.writer, err = os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.recoverSpooledTimes()
+	return nil
+}
+
+// spooledRange records that the records up to end, from the end of the
+// previous range, were spooled no earlier than spooledAt. It dates the
+// oldest unacked record whether or not it has been read back yet.
+type spooledRange struct {
+	end       uint64
+	spooledAt time.Time
+}
+
+// recoverSpooledTimes dates the unacked records of each segment by the first
+// of them, which is the oldest.
+func (s *Spool) recoverSpooledTimes() {
+	for i, seg := range s.segments {
+		end := s.written
+		if i+1 < len(s.segments) {
+			end = s.segments[i+1].start
+		}
+		from := seg.start
+		if from < s.acked {
+			from = s.acked
+		}
+		if from >= end {
+			continue
+		}
+
+		file, reader, err := openSegmentAt(seg, from)
+		if err != nil {
+			continue
+		}
+		record, _, err := readRecord(reader)
+		file.Close()
+		if err != nil {
+			continue
+		}
+		s.spooled = append(s.spooled, spooledRange{end: end, spooledAt: record.SpooledAt})
+	}
+}
+
+// Run moves messages from in to the spool and from the spool to out until
+// ctx is cancelled. Messages from in are acked once they are on disk; the
+// messages sent to out ack their spool record.
+func (s *Spool) Run(ctx context.Context, in <-chan kafka.LogMessage, out chan<- kafka.LogMessage) {
+	var wg sync.WaitGroup
+
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		s.writeLoop(ctx, in)
+	}()
+	go func() {
+		defer wg.Done()
+		s.readLoop(ctx, out)
+	}()
+	go func() {
+		defer wg.Done()
+		s.maintainLoop(ctx)
+	}()
+
+	wg.Wait()
+}
+
+// Close writes a final checkpoint and closes the current segment. It must
+// only be called after Run has returned; records acked afterwards are
+// redelivered on the next start.
+func (s *Spool) Close() error {
+	s.maintain()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.writer.Close()
+}
+
+func (s *Spool) writeLoop(ctx context.Context, in <-chan kafka.LogMessage) {
+	backoff := initialRetryBackoff
+
+	for {
+		var batch []kafka.LogMessage
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-in:
+			batch = append(batch, msg)
+		}
+
+	drain:
+		for len(batch) < spoolWriteBatch {
+			select {
+			case msg := <-in:
+				batch = append(batch, msg)
+			default:
+				break drain
+			}
+		}
+
+		// Flush markers need nothing from the spool: everything before
+		// them is acked as soon as it is written.
+		batch = dropMarkers(batch)
+		if len(batch) == 0 {
+			continue
+		}
+
+		for {
+			err := s.append(ctx, batch)
+			if err == nil {
+				backoff = initialRetryBackoff
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+
+			s.logger.Errorf("Failed to write %d messages to spool, retrying in %s: %v", len(batch), backoff, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > maxRetryBackoff {
+				backoff = maxRetryBackoff
+			}
+		}
+
+		for _, msg := range batch {
+			msg.Ack()
+		}
+	}
+}
+
+func dropMarkers(batch []kafka.LogMessage) []kafka.LogMessage {
+	messages := batch[:0]
+	for _, msg := range batch {
+		if msg.Revoked() == nil {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// append writes batch to the current segment and syncs it, waiting for the
+// quota to allow it first. On failure nothing from the batch is kept.
+func (s *Spool) append(ctx context.Context, batch []kafka.LogMessage) error {
+	now := time.Now()
+
+	var data []byte
+	for _, msg := range batch {
+		record, err := encodeRecord(spoolRecord{
+			Entry:     msg.Entry,
+			Topic:     msg.Topic,
+			Partition: msg.Partition,
+			Offset:    msg.Offset,
+			Index:     msg.Index,
+			SpooledAt: now,
+		})
+		if err != nil {
+			return err
+		}
+		data = append(data, record...)
+	}
+
+	if err := s.waitForSpace(ctx, int64(len(data))); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current := &s.segments[len(s.segments)-1]
+	if s.rollRequested || (current.size > 0 && current.size >= s.config.SegmentBytes) {
+		if err := s.roll(); err != nil {
+			return err
+		}
+		current = &s.segments[len(s.segments)-1]
+	}
+
+	if _, err := s.writer.Write(data); err != nil {
+		s.writer.Truncate(current.size)
+		return err
+	}
+	if err := s.writer.Sync(); err != nil {
+		s.writer.Truncate(current.size)
+		return err
+	}
+
+	current.size += int64(len(data))
+	s.usage += int64(len(data))
+	s.written += uint64(len(batch))
+
+	// Batches spooled within a second share a range to bound the list
+	// during a long outage.
+	if n := len(s.spooled); n > 0 && now.Sub(s.spooled[n-1].spooledAt) < time.Second {
+		s.spooled[n-1].end = s.written
+	} else {
+		s.spooled = append(s.spooled, spooledRange{end: s.written, spooledAt: now})
+	}
+
+	notify(s.dataReady)
+	return nil
+}
+
+// waitForSpace blocks until need more bytes fit in the quota. An empty spool
+// always accepts a write so a single oversized batch cannot wedge it.
+func (s *Spool) waitForSpace(ctx context.Context, need int64) error {
+	full := false
+
+	for {
+		s.mutex.Lock()
+		if s.usage == 0 || s.usage+need <= s.config.MaxBytes {
+			s.mutex.Unlock()
+			return nil
+		}
+		// The current segment can only be deleted after it has been
+		// rolled, so start a new one to let it go once it is acked.
+		if s.segments[len(s.segments)-1].size > 0 {
+			if err := s.roll(); err != nil {
+				s.mutex.Unlock()
+				return err
+			}
+		}
+		s.mutex.Unlock()
+
+		if !full {
+			full = true
+			spoolFull.Inc()
+			s.logger.Warnf("Spool reached its %d byte quota, pausing consumption", s.config.MaxBytes)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.spaceReady:
+		}
+	}
+}
+
+// roll closes the current segment and starts a new one at the next sequence
+// number. The caller must hold the mutex.
+func (s *Spool) roll() error {
+	if err := s.writer.Close(); err != nil {
+		return err
+	}
+	s.rollRequested = false
+	return s.createSegment(s.written)
+}
+
+func (s *Spool) createSegment(start uint64) error {
+	path := segmentPath(s.config.Dir, start)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(s.config.Dir); err != nil {
+		file.Close()
+		return err
+	}
+
+	s.writer = file
+	s.segments = append(s.segments, segment{start: start, path: path})
+	return nil
+}
+
+func (s *Spool) readLoop(ctx context.Context, out chan<- kafka.LogMessage) {
+	s.mutex.Lock()
+	seq := s.acked
+	s.mutex.Unlock()
+
+	var file *os.File
+	var reader *bufio.Reader
+	var fileStart uint64
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	for {
+		s.mutex.Lock()
+		available := seq < s.written
+		seg, next, hasNext := s.segmentFor(seq)
+		s.mutex.Unlock()
+
+		if !available {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.dataReady:
+			}
+			continue
+		}
+
+		if file == nil || seg.start != fileStart {
+			if file != nil {
+				file.Close()
+			}
+			var err error
+			file, reader, err = openSegmentAt(seg, seq)
+			if err != nil {
+				s.logger.Errorf("Failed to open spool segment %s: %v", seg.path, err)
+				file = nil
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(initialRetryBackoff):
+				}
+				continue
+			}
+			fileStart = seg.start
+		}
+
+		record, _, err := readRecord(reader)
+		if err != nil {
+			// The rest of a damaged segment cannot be located, so skip
+			// ahead to the next one. If the damage is in the segment
+			// still being written, the writer starts a new one.
+			s.mutex.Lock()
+			end := next
+			if !hasNext {
+				end = s.written
+				s.rollRequested = true
+			}
+			s.mutex.Unlock()
+
+			s.logger.Errorf("Dropping %d records from damaged spool segment %s: %v", end-seq, seg.path, err)
+			for lost := seq; lost < end; lost++ {
+				s.ack(lost)
+			}
+			seq = end
+			file.Close()
+			file = nil
+			continue
+		}
+
+		current := seq
+		msg := kafka.NewLogMessage(record.Entry, func() {
+			s.ack(current)
+		})
+		msg.Topic = record.Topic
+		msg.Partition = record.Partition
+		msg.Offset = record.Offset
+		msg.Index = record.Index
+
+		select {
+		case out <- msg:
+		case <-ctx.Done():
+			return
+		}
+		seq++
+	}
+}
+
+// segmentFor returns the segment holding seq and the start of the segment
+// after it, if any. The caller must hold the mutex.
+func (s *Spool) segmentFor(seq uint64) (segment, uint64, bool) {
+	for i := len(s.segments) - 1; i >= 0; i-- {
+		if s.segments[i].start <= seq {
+			if i+1 < len(s.segments) {
+				return s.segments[i], s.segments[i+1].start, true
+			}
+			return s.segments[i], 0, false
+		}
+	}
+	return s.segments[0], 0, len(s.segments) > 1
+}
+
+// openSegmentAt opens seg positioned at the record with sequence seq.
+func openSegmentAt(seg segment, seq uint64) (*os.File, *bufio.Reader, error) {
+	file, err := os.Open(seg.path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader := bufio.NewReader(file)
+	for skip := seg.start; skip < seq; skip++ {
+		if _, _, err := readRecord(reader); err != nil {
+			file.Close()
+			if err == io.EOF {
+				err = errCorruptRecord
+			}
+			return nil, nil, err
+		}
+	}
+	return file, reader, nil
+}
+
+// ack marks record seq as indexed and advances the acked position past every
+// contiguous acked record.
+func (s *Spool) ack(seq uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if seq < s.acked {
+		return
+	}
+	if seq != s.acked {
+		s.pending[seq] = true
+		return
+	}
+
+	s.acked++
+	for s.pending[s.acked] {
+		delete(s.pending, s.acked)
+		s.acked++
+	}
+}
+
+func (s *Spool) maintainLoop(ctx context.Context) {
+	ticker := time.NewTicker(spoolMaintainPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.maintain()
+		}
+	}
+}
+
+// maintain persists the acked position, deletes fully acked segments and
+// refreshes the spool metrics.
+func (s *Spool) maintain() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.acked != s.checkpointed {
+		if err := writeCheckpoint(s.config.Dir, s.acked); err != nil {
+			s.logger.Errorf("Failed to write spool checkpoint: %v", err)
+		} else {
+			s.checkpointed = s.acked
+		}
+	}
+
+	freed := false
+	for len(s.segments) > 1 && s.segments[1].start <= s.checkpointed {
+		if err := os.Remove(s.segments[0].path); err != nil {
+			s.logger.Errorf("Failed to remove spool segment %s: %v", s.segments[0].path, err)
+			break
+		}
+		s.usage -= s.segments[0].size
+		s.segments = s.segments[1:]
+		freed = true
+	}
+	if freed {
+		notify(s.spaceReady)
+	}
+
+	s.updateMetricsLocked()
+}
+
+func (s *Spool) updateMetrics() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.updateMetricsLocked()
+}
+
+func (s *Spool) updateMetricsLocked() {
+	spoolRecords.Set(float64(s.written - s.acked))
+	spoolBytes.Set(float64(s.usage))
+	spoolSegments.Set(float64(len(s.segments)))
+
+	spoolOldestAge.Set(s.oldestAgeLocked().Seconds())
+}
+
+// oldestAgeLocked returns how long ago the oldest unacked record was spooled,
+// dropping the ranges that have been acked. The caller must hold the mutex.
+func (s *Spool) oldestAgeLocked() time.Duration {
+	i := 0
+	for i < len(s.spooled) && s.spooled[i].end <= s.acked {
+		i++
+	}
+	s.spooled = s.spooled[i:]
+
+	if len(s.spooled) == 0 {
+		return 0
+	}
+	return time.Since(s.spooled[0].spooledAt)
+}
+
+func readCheckpoint(dir string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, checkpointFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	checkpoint, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid spool checkpoint: %v", err)
+	}
+	return checkpoint, nil
+}
+
+// writeCheckpoint replaces the checkpoint file atomically.
+func writeCheckpoint(dir string, checkpoint uint64) error {
+	path := filepath.Join(dir, checkpointFile)
+	tmp := path + ".tmp"
+
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(checkpoint, 10)+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// notify wakes a goroutine waiting on ch without blocking.
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,434 @@
+package ingestion
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"awesomeProject6/internal/models"
+	"awesomeProject6/pkg/kafka"
+)
+
+// spoolRun runs a spool between an input channel standing in for the
+// consumer and an output channel standing in for the batch processor.
+type spoolRun struct {
+	spool  *Spool
+	in     chan kafka.LogMessage
+	out    chan kafka.LogMessage
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mutex sync.Mutex
+	acked []string
+}
+
+func openTestSpool(t *testing.T, config SpoolConfig) *Spool {
+	t.Helper()
+
+	spool, err := OpenSpool(config)
+	if err != nil {
+		t.Fatalf("OpenSpool: %v", err)
+	}
+	spool.logger.SetOutput(io.Discard)
+	return spool
+}
+
+func startSpool(t *testing.T, config SpoolConfig) *spoolRun {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &spoolRun{
+		spool:  openTestSpool(t, config),
+		in:     make(chan kafka.LogMessage),
+		out:    make(chan kafka.LogMessage),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(run.done)
+		run.spool.Run(ctx, run.in, run.out)
+	}()
+	t.Cleanup(run.stop)
+	return run
+}
+
+// stop ends Run and closes the spool. It may be called more than once.
+func (r *spoolRun) stop() {
+	select {
+	case <-r.done:
+		return
+	default:
+	}
+	r.cancel()
+	<-r.done
+	r.spool.Close()
+}
+
+// send hands the spool a message and returns without waiting for it to be
+// written; acked reports the messages whose Kafka ack has fired.
+func (r *spoolRun) send(t *testing.T, message string) {
+	t.Helper()
+
+	msg := kafka.NewLogMessage(models.LogEntry{Message: message}, func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.acked = append(r.acked, message)
+	})
+	select {
+	case r.in <- msg:
+	case <-time.After(time.Second):
+		t.Fatalf("spool did not accept %q", message)
+	}
+}
+
+func (r *spoolRun) ackedMessages() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]string(nil), r.acked...)
+}
+
+// waitAcked waits until n messages have been acked to Kafka.
+func (r *spoolRun) waitAcked(n int, wait time.Duration) bool {
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) {
+		if len(r.ackedMessages()) >= n {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func (r *spoolRun) receive(t *testing.T) kafka.LogMessage {
+	t.Helper()
+
+	select {
+	case msg := <-r.out:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a spooled message")
+		return kafka.LogMessage{}
+	}
+}
+
+// expectMessages receives len(want) messages and checks they arrive in order.
+func (r *spoolRun) expectMessages(t *testing.T, want ...string) []kafka.LogMessage {
+	t.Helper()
+
+	var msgs []kafka.LogMessage
+	var got []string
+	for range want {
+		msg := r.receive(t)
+		msgs = append(msgs, msg)
+		got = append(got, msg.Entry.Message)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("spool delivered %q, want %q", got, want)
+	}
+	return msgs
+}
+
+func (r *spoolRun) expectNothing(t *testing.T) {
+	t.Helper()
+
+	select {
+	case msg := <-r.out:
+		t.Fatalf("spool delivered %q, want nothing", msg.Entry.Message)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func (r *spoolRun) sendAll(t *testing.T, messages ...string) {
+	t.Helper()
+
+	for _, message := range messages {
+		r.send(t, message)
+	}
+	if !r.waitAcked(len(messages), time.Second) {
+		t.Fatalf("acked %d of %d messages", len(r.ackedMessages()), len(messages))
+	}
+}
+
+func TestSpoolResumesAfterRestart(t *testing.T) {
+	config := SpoolConfig{Dir: t.TempDir()}
+
+	first := startSpool(t, config)
+	first.sendAll(t, "a", "b", "c", "d")
+	msgs := first.expectMessages(t, "a", "b", "c", "d")
+	msgs[0].Ack()
+	msgs[1].Ack()
+	msgs[3].Ack()
+	first.stop()
+
+	// Everything after the first unacked record is delivered again.
+	second := startSpool(t, config)
+	msgs = second.expectMessages(t, "c", "d")
+	second.expectNothing(t)
+
+	second.sendAll(t, "e")
+	msgs = append(msgs, second.expectMessages(t, "e")...)
+	for _, msg := range msgs {
+		msg.Ack()
+	}
+	second.stop()
+
+	third := startSpool(t, config)
+	third.expectNothing(t)
+}
+
+func TestSpoolSkipsDamagedRecords(t *testing.T) {
+	tests := []struct {
+		name         string
+		segmentBytes int64
+		segment      int
+		damage       func(t *testing.T, path string)
+		want         []string
+	}{
+		{
+			name:   "torn tail",
+			damage: tearTail,
+			want:   []string{"a", "b", "c", "d"},
+		},
+		{
+			name:   "corrupt last record",
+			damage: corruptLastRecord,
+			want:   []string{"a", "b", "c"},
+		},
+		{
+			// A damaged segment that is no longer written is skipped
+			// from the damage to its end when it is read back.
+			name:         "corrupt earlier segment",
+			segmentBytes: 1,
+			segment:      1,
+			damage:       corruptLastRecord,
+			want:         []string{"a", "c", "d"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := SpoolConfig{Dir: t.TempDir(), SegmentBytes: tt.segmentBytes}
+
+			first := startSpool(t, config)
+			for i, message := range []string{"a", "b", "c", "d"} {
+				first.send(t, message)
+				if !first.waitAcked(i+1, time.Second) {
+					t.Fatalf("%q was not written", message)
+				}
+			}
+			first.stop()
+
+			segments, err := listSegments(config.Dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			segment := len(segments) - 1
+			if tt.segment > 0 {
+				segment = tt.segment
+			}
+			tt.damage(t, segments[segment].path)
+
+			second := startSpool(t, config)
+			msgs := second.expectMessages(t, tt.want...)
+			second.expectNothing(t)
+
+			// Writing carries on after the damage.
+			second.sendAll(t, "e")
+			msgs = append(msgs, second.expectMessages(t, "e")...)
+			for _, msg := range msgs {
+				msg.Ack()
+			}
+			second.stop()
+
+			third := startSpool(t, config)
+			third.expectNothing(t)
+		})
+	}
+}
+
+func TestSpoolBlocksAtQuota(t *testing.T) {
+	run := startSpool(t, SpoolConfig{Dir: t.TempDir(), MaxBytes: 4096})
+
+	// Send one message at a time so each is its own write, until one is
+	// held back by the quota.
+	sent := 0
+	for ; sent < 100; sent++ {
+		run.send(t, fmt.Sprintf("message %03d with some padding to fill the spool quickly", sent))
+		if !run.waitAcked(sent+1, 200*time.Millisecond) {
+			break
+		}
+	}
+	if sent == 100 {
+		t.Fatal("spool accepted every message without reaching its quota")
+	}
+
+	run.spool.mutex.Lock()
+	usage := run.spool.usage
+	run.spool.mutex.Unlock()
+	if usage > 4096 {
+		t.Errorf("spool uses %d bytes, over its 4096 byte quota", usage)
+	}
+
+	// Indexing what was spooled frees the segments, letting the held back
+	// message in.
+	for i := 0; i < sent; i++ {
+		run.receive(t).Ack()
+	}
+	run.spool.maintain()
+	if !run.waitAcked(sent+1, time.Second) {
+		t.Fatal("held back message was not written after space was freed")
+	}
+	if msg := run.receive(t); msg.Entry.Message != fmt.Sprintf("message %03d with some padding to fill the spool quickly", sent) {
+		t.Errorf("delivered %q after the quota was lifted", msg.Entry.Message)
+	}
+}
+
+func TestSpoolAckOrder(t *testing.T) {
+	config := SpoolConfig{Dir: t.TempDir()}
+
+	run := startSpool(t, config)
+	run.sendAll(t, "a", "b", "c", "d", "e")
+	msgs := run.expectMessages(t, "a", "b", "c", "d", "e")
+
+	tests := []struct {
+		ack        int
+		checkpoint uint64
+	}{
+		{ack: 4, checkpoint: 0},
+		{ack: 2, checkpoint: 0},
+		{ack: 1, checkpoint: 0},
+		{ack: 0, checkpoint: 3},
+		{ack: 3, checkpoint: 5},
+	}
+	for _, tt := range tests {
+		msgs[tt.ack].Ack()
+		run.spool.maintain()
+
+		checkpoint, err := readCheckpoint(config.Dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checkpoint != tt.checkpoint {
+			t.Errorf("after acking record %d checkpoint is %d, want %d", tt.ack, checkpoint, tt.checkpoint)
+		}
+	}
+}
+
+func TestSpoolDeletesAckedSegments(t *testing.T) {
+	config := SpoolConfig{Dir: t.TempDir(), MaxBytes: 1 << 20, SegmentBytes: 256}
+
+	run := startSpool(t, config)
+	var messages []string
+	for i := 0; i < 10; i++ {
+		messages = append(messages, fmt.Sprintf("message %d", i))
+		run.sendAll(t, messages[i])
+	}
+	msgs := run.expectMessages(t, messages...)
+
+	segments, err := listSegments(config.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) < 3 {
+		t.Fatalf("spool wrote %d segments, want several", len(segments))
+	}
+
+	// Acking the records of the first segment deletes only that segment.
+	for _, msg := range msgs[:segments[1].start] {
+		msg.Ack()
+	}
+	run.spool.maintain()
+	remaining, err := listSegments(config.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != len(segments)-1 || remaining[0].start != segments[1].start {
+		t.Errorf("after acking the first segment %d segments remain starting at %d, want %d starting at %d",
+			len(remaining), remaining[0].start, len(segments)-1, segments[1].start)
+	}
+
+	// The segment being written is kept even when fully acked.
+	for _, msg := range msgs[segments[1].start:] {
+		msg.Ack()
+	}
+	run.spool.maintain()
+	remaining, err = listSegments(config.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 || remaining[0].start != segments[len(segments)-1].start {
+		t.Errorf("after acking everything %d segments remain, want only the current one", len(remaining))
+	}
+	run.spool.mutex.Lock()
+	usage := run.spool.usage
+	run.spool.mutex.Unlock()
+	if usage != remaining[0].size {
+		t.Errorf("spool usage %d, want the current segment's %d bytes", usage, remaining[0].size)
+	}
+}
+
+func TestSpoolOldestAge(t *testing.T) {
+	config := SpoolConfig{Dir: t.TempDir()}
+
+	first := startSpool(t, config)
+	first.sendAll(t, "a", "b")
+	msgs := first.expectMessages(t, "a")
+	msgs[0].Ack()
+	first.stop()
+
+	// After a restart the unread record is still dated by when it was
+	// spooled.
+	time.Sleep(20 * time.Millisecond)
+	spool := openTestSpool(t, config)
+	defer spool.Close()
+
+	spool.mutex.Lock()
+	age := spool.oldestAgeLocked()
+	spool.mutex.Unlock()
+	if age < 20*time.Millisecond {
+		t.Errorf("oldest record age %s, want at least 20ms", age)
+	}
+
+	spool.ack(1)
+	spool.mutex.Lock()
+	age = spool.oldestAgeLocked()
+	spool.mutex.Unlock()
+	if age != 0 {
+		t.Errorf("oldest record age %s with nothing unacked, want 0", age)
+	}
+}
+
+// tearTail appends the start of a record that was never finished.
+func tearTail(t *testing.T, path string) {
+	t.Helper()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.Write([]byte{0, 0, 1, 0, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// corruptLastRecord flips a payload byte of the last record so its checksum
+// no longer matches.
+func corruptLastRecord(t *testing.T, path string) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-2] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
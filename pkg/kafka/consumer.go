@@ -2,77 +2,340 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
+	"math/rand"
 	"github.com/IBM/sarama"
 	"github.com/sirupsen/logrus"
 	"awesomeProject6/internal/models"
+	"awesomeProject6/pkg/parser"
+	"time"
 )
 
 type Consumer struct {
-	consumer sarama.ConsumerGroup
-	topics   []string
-	handler  *ConsumerGroupHandler
-	logger   *logrus.Logger
+	client          sarama.Client
+	consumer        sarama.ConsumerGroup
+	subscriptions   *subscriptions
+	refreshInterval time.Duration
+	retry           RetryConfig
+	handler         *ConsumerGroupHandler
+	health          *health
+	logger          *logrus.Logger
+}
+
+// ConsumerConfig configures a Consumer. GroupID defaults to
+// "log-ingestion-group". When a subscription uses a pattern, the cluster's
+// topics are checked against it every RefreshInterval (one minute by
+// default) and the consumer rejoins the group when the matching set changes.
+// On a rebalance, the consumer waits up to DrainTimeout (30 seconds by
+// default) for the messages of its revoked partitions to be stored before
+// releasing them.
+type ConsumerConfig struct {
+	Brokers         []string
+	Security        SecurityConfig
+	GroupID         string
+	Subscriptions   []Subscription
+	RefreshInterval time.Duration
+	DrainTimeout    time.Duration
+	Retry           RetryConfig
+}
+
+// RetryConfig controls how Start recovers when consuming fails. Attempts are
+// spaced by an exponential backoff from InitialBackoff (one second by
+// default) up to MaxBackoff (one minute by default), each randomly shortened
+// by up to half. Start gives up once consuming has been failing for
+// GiveUpAfter; zero retries forever.
+type RetryConfig struct {
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	GiveUpAfter    time.Duration
+}
+
+// backoff returns the delay before the next attempt after failures
+// consecutive failures.
+func (r RetryConfig) backoff(failures int) time.Duration {
+	delay := r.InitialBackoff
+	for i := 1; i < failures && delay < r.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.MaxBackoff {
+		delay = r.MaxBackoff
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// LogMessage is a decoded log entry waiting to be indexed. Ack marks the
+// Kafka record it came from as consumed, so it must only be called once the
+// entry has been stored. Index is the index prefix the entry is written to,
+// or empty for the configured index.
+//
+// When partitions are revoked, the consumer follows their last messages with
+// a flush marker, a LogMessage without an entry for which Revoked returns the
+// partitions. Every stage must send on whatever it holds from those
+// partitions before passing the marker along, and must not store the marker.
+type LogMessage struct {
+	Entry     models.LogEntry
+	Topic     string
+	Partition int32
+	Offset    int64
+	Index     string
+	ack       func()
+	revoked   []Partition
+}
+
+func NewLogMessage(entry models.LogEntry, ack func()) LogMessage {
+	return LogMessage{Entry: entry, ack: ack}
+}
+
+func (m LogMessage) Ack() {
+	if m.ack != nil {
+		m.ack()
+	}
+}
+
+// Revoked returns the revoked partitions if m is a flush marker and nil
+// otherwise.
+func (m LogMessage) Revoked() []Partition {
+	return m.revoked
 }
 
 type ConsumerGroupHandler struct {
-	logChan chan models.LogEntry
-	logger  *logrus.Logger
+	logChan       chan LogMessage
+	subscriptions *subscriptions
+	parsers       *parser.Registry
+	dlq           *Producer
+	retry         RetryConfig
+	claims        *claims
+	drainTimeout  time.Duration
+	done          <-chan struct{}
+	health        *health
+	logger        *logrus.Logger
 }
 
-func NewConsumer(brokers []string, groupID string, topics []string, logChan chan models.LogEntry) (*Consumer, error) {
+// NewConsumer creates a consumer group member that decodes log entries into
+// logChan, using the parser named by each message's format header or by its
+// subscription. Offsets are only committed once a message is acked. Messages
+// that cannot be decoded are forwarded to dlq, which may be nil to only log
+// them.
+func NewConsumer(cfg ConsumerConfig, logChan chan LogMessage, parsers *parser.Registry, dlq *Producer) (*Consumer, error) {
+	subs, err := newSubscriptions(cfg.Subscriptions)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range cfg.Subscriptions {
+		if sub.Format != "" && !parsers.Has(sub.Format) {
+			return nil, fmt.Errorf("unknown log format %q in subscription", sub.Format)
+		}
+	}
+
+	groupID := cfg.GroupID
+	if groupID == "" {
+		groupID = "log-ingestion-group"
+	}
+	refreshInterval := cfg.RefreshInterval
+	if refreshInterval <= 0 {
+		refreshInterval = time.Minute
+	}
+	drainTimeout := cfg.DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = 30 * time.Second
+	}
+	retry := cfg.Retry
+	if retry.InitialBackoff <= 0 {
+		retry.InitialBackoff = time.Second
+	}
+	if retry.MaxBackoff <= 0 {
+		retry.MaxBackoff = time.Minute
+	}
+
 	config := sarama.NewConfig()
 	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
 	config.Consumer.Offsets.Initial = sarama.OffsetOldest
 	config.Consumer.Return.Errors = true
 
-	consumer, err := sarama.NewConsumerGroup(brokers, groupID, config)
+	if err := cfg.Security.apply(config); err != nil {
+		return nil, err
+	}
+
+	client, err := sarama.NewClient(cfg.Brokers, config)
+	if err != nil {
+		return nil, err
+	}
+
+	consumer, err := sarama.NewConsumerGroupFromClient(groupID, client)
 	if err != nil {
+		client.Close()
 		return nil, err
 	}
 
 	logger := logrus.New()
+	health := newHealth()
 	handler := &ConsumerGroupHandler{
-		logChan: logChan,
-		logger:  logger,
+		logChan:       logChan,
+		subscriptions: subs,
+		parsers:       parsers,
+		dlq:           dlq,
+		retry:         retry,
+		claims:        newClaims(),
+		drainTimeout:  drainTimeout,
+		health:        health,
+		logger:        logger,
 	}
 
 	return &Consumer{
-		consumer: consumer,
-		topics:   topics,
-		handler:  handler,
-		logger:   logger,
+		client:          client,
+		consumer:        consumer,
+		subscriptions:   subs,
+		refreshInterval: refreshInterval,
+		retry:           retry,
+		handler:         handler,
+		health:          health,
+		logger:          logger,
 	}, nil
 }
 
+// Start consumes until ctx is cancelled or the retry policy gives up. A
+// failed attempt to join the group or consume is retried with exponential
+// backoff and jitter; Health reports the current state.
 func (c *Consumer) Start(ctx context.Context) error {
+	c.handler.done = ctx.Done()
+	go c.reportLag(ctx)
+	go c.drainErrors()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
-			err := c.consumer.Consume(ctx, c.topics, c.handler)
-			if err != nil {
-				c.logger.Errorf("Error consuming messages: %v", err)
-				return err
-			}
+		}
+
+		err := c.consume(ctx)
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == sarama.ErrClosedConsumerGroup {
+			return err
+		}
+
+		failures, since := c.health.fail(err)
+		consumerRestarts.Inc()
+		if c.retry.GiveUpAfter > 0 && time.Since(since) >= c.retry.GiveUpAfter {
+			c.health.giveUp(err)
+			return fmt.Errorf("giving up after failing for %s: %v", time.Since(since).Round(time.Second), err)
+		}
+
+		delay := c.retry.backoff(failures)
+		c.logger.Errorf("Error consuming messages (attempt %d), retrying in %s: %v", failures, delay, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
 		}
 	}
 }
 
+// consume runs one consumer group session over the subscribed topics. It
+// returns nil when the session ends for a rebalance or a change of topics.
+func (c *Consumer) consume(ctx context.Context) error {
+	topics, err := c.resolveTopics()
+	if err != nil {
+		return fmt.Errorf("failed to resolve subscribed topics: %v", err)
+	}
+	if len(topics) == 0 {
+		c.logger.Warnf("No topics match the subscriptions, checking again in %s", c.refreshInterval)
+		select {
+		case <-ctx.Done():
+		case <-time.After(c.refreshInterval):
+		}
+		return nil
+	}
+
+	sessionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if c.subscriptions.hasPatterns() {
+		go c.watchTopics(sessionCtx, topics, cancel)
+	}
+
+	return c.consumer.Consume(sessionCtx, topics, c.handler)
+}
+
+// drainErrors logs the errors sarama reports outside of Consume, such as
+// failed fetches or offset commits, until the consumer group is closed.
+func (c *Consumer) drainErrors() {
+	for err := range c.consumer.Errors() {
+		c.logger.Errorf("Kafka consumer error: %v", err)
+		consumerErrors.Inc()
+		c.health.record(err)
+	}
+}
+
+// Health returns the current state of the consumer.
+func (c *Consumer) Health() HealthStatus {
+	return c.health.get()
+}
+
 func (c *Consumer) Close() error {
-	return c.consumer.Close()
+	err := c.consumer.Close()
+	if clientErr := c.client.Close(); err == nil && clientErr != sarama.ErrClosedClient {
+		err = clientErr
+	}
+	return err
 }
 
-func (h *ConsumerGroupHandler) Setup(sarama.ConsumerGroupSession) error {
-	return nil
+// resolveTopics returns the topics currently covered by the subscriptions,
+// refreshing the cluster metadata when patterns need matching.
+func (c *Consumer) resolveTopics() ([]string, error) {
+	if !c.subscriptions.hasPatterns() {
+		return c.subscriptions.resolve(nil), nil
+	}
+
+	if err := c.client.RefreshMetadata(); err != nil {
+		return nil, err
+	}
+	available, err := c.client.Topics()
+	if err != nil {
+		return nil, err
+	}
+	return c.subscriptions.resolve(available), nil
+}
+
+// watchTopics ends the current session through cancel once the topics
+// matching the subscriptions differ from current, so Start rejoins the group
+// with the new set.
+func (c *Consumer) watchTopics(ctx context.Context, current []string, cancel context.CancelFunc) {
+	ticker := time.NewTicker(c.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			topics, err := c.resolveTopics()
+			if err != nil {
+				c.logger.Warnf("Failed to refresh subscribed topics: %v", err)
+				continue
+			}
+			if !sameTopics(topics, current) {
+				c.logger.Infof("Subscribed topics changed from %v to %v, rejoining consumer group", current, topics)
+				cancel()
+				return
+			}
+		}
+	}
 }
 
-func (h *ConsumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error {
+func (h *ConsumerGroupHandler) Setup(sarama.ConsumerGroupSession) error {
+	h.health.ok()
 	return nil
 }
 
 func (h *ConsumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	tracker := newOffsetTracker(session, claim.Topic(), claim.Partition(), claim.InitialOffset())
+	h.claims.add(tracker)
+
 	for {
 		select {
 		case message := <-claim.Messages():
@@ -80,17 +343,100 @@ func (h *ConsumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession,
 				return nil
 			}
 
-			var logEntry models.LogEntry
-			if err := json.Unmarshal(message.Value, &logEntry); err != nil {
-				h.logger.Errorf("Failed to unmarshal log entry: %v", err)
+			offset := message.Offset
+			tracker.track(offset)
+			messagesConsumed.WithLabelValues(message.Topic).Inc()
+			bytesConsumed.WithLabelValues(message.Topic).Add(float64(len(message.Value)))
+
+			logEntry, index, err := h.decode(message)
+			if err != nil {
+				h.logger.Errorf("Failed to decode log entry: %v", err)
+				decodeFailures.WithLabelValues(message.Topic).Inc()
+				if h.deadLetter(session.Context(), message, err) {
+					tracker.ack(offset)
+				}
 				continue
 			}
 
-			h.logChan <- logEntry
-			session.MarkMessage(message, "")
+			msg := NewLogMessage(logEntry, func() {
+				tracker.ack(offset)
+			})
+			msg.Topic = message.Topic
+			msg.Partition = message.Partition
+			msg.Offset = offset
+			msg.Index = index
+
+			// Block while the pipeline is busy so a slow or unavailable
+			// Elasticsearch pushes back on the consumer instead of dropping
+			// logs, but give up the claim promptly on rebalance.
+			select {
+			case h.logChan <- msg:
+			case <-session.Context().Done():
+				return nil
+			}
 
 		case <-session.Context().Done():
 			return nil
 		}
 	}
-}
\ No newline at end of file
+}
+
+// decode parses a message with the format named in its header or by its
+// subscription and returns the entry with the subscription's index prefix.
+func (h *ConsumerGroupHandler) decode(message *sarama.ConsumerMessage) (models.LogEntry, string, error) {
+	var sub Subscription
+	if matched, ok := h.subscriptions.match(message.Topic); ok {
+		sub = matched.Subscription
+	}
+
+	format, _ := MessageHeader(message, parser.FormatHeader)
+	if format == "" {
+		format = sub.Format
+	}
+	service := sub.Service
+	if service == "" {
+		service = message.Topic
+	}
+
+	entry, err := h.parsers.Parse(format, message.Value, service, message.Timestamp)
+	return entry, sub.Index, err
+}
+
+// deadLetter forwards a message that could not be decoded and reports whether
+// it may be committed. Without a dead-letter topic the message is dropped. A
+// failed attempt is retried with the consumer's backoff, since the partition
+// cannot be committed past the message meanwhile; if ctx ends first the
+// message stays uncommitted and is redelivered to the partition's next owner.
+func (h *ConsumerGroupHandler) deadLetter(ctx context.Context, message *sarama.ConsumerMessage, cause error) bool {
+	if h.dlq == nil {
+		return true
+	}
+
+	failures := 0
+	for {
+		err := forwardToDeadLetter(h.dlq, message, cause)
+		if err == nil {
+			return true
+		}
+
+		failures++
+		if failures == 1 {
+			retrying := deadLetterRetrying.WithLabelValues(message.Topic)
+			retrying.Inc()
+			defer retrying.Dec()
+		}
+		delay := h.retry.backoff(failures)
+		h.logger.WithFields(logrus.Fields{
+			"topic":     message.Topic,
+			"partition": message.Partition,
+			"offset":    message.Offset,
+			"attempt":   failures,
+		}).Errorf("Failed to forward message to dead-letter topic, retrying in %s: %v", delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package kafka
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Headers attached to every message forwarded to the dead-letter topic.
+const (
+	HeaderSourceTopic     = "dlq.topic"
+	HeaderSourcePartition = "dlq.partition"
+	HeaderSourceOffset    = "dlq.offset"
+	HeaderError           = "dlq.error"
+	HeaderFailedAt        = "dlq.failed_at"
+)
+
+var (
+	deadLetterMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_ingestion_dlq_messages_total",
+		Help: "Messages forwarded to the dead-letter topic.",
+	}, []string{"topic"})
+
+	deadLetterFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_ingestion_dlq_failures_total",
+		Help: "Messages that could not be forwarded to the dead-letter topic.",
+	}, []string{"topic"})
+
+	deadLetterRetrying = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "log_ingestion_dlq_retrying",
+		Help: "Messages holding up their partition while forwarding them to the dead-letter topic is retried.",
+	}, []string{"topic"})
+
+	deadLetterReplayed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_ingestion_dlq_replayed_total",
+		Help: "Dead-letter messages replayed to their source topic.",
+	}, []string{"topic"})
+)
+
+// forwardToDeadLetter sends the raw message together with the reason it could
+// not be processed to the dead-letter producer. The original headers are kept.
+func forwardToDeadLetter(dlq *Producer, message *sarama.ConsumerMessage, cause error) error {
+	headers := make(map[string]string, len(message.Headers)+5)
+	for _, header := range message.Headers {
+		if header != nil {
+			headers[string(header.Key)] = string(header.Value)
+		}
+	}
+	headers[HeaderSourceTopic] = message.Topic
+	headers[HeaderSourcePartition] = strconv.FormatInt(int64(message.Partition), 10)
+	headers[HeaderSourceOffset] = strconv.FormatInt(message.Offset, 10)
+	headers[HeaderError] = cause.Error()
+	headers[HeaderFailedAt] = time.Now().UTC().Format(time.RFC3339)
+
+	if err := dlq.Send("", message.Key, message.Value, headers); err != nil {
+		deadLetterFailures.WithLabelValues(message.Topic).Inc()
+		return err
+	}
+
+	deadLetterMessages.WithLabelValues(message.Topic).Inc()
+	return nil
+}
+
+// DeadLetterLog sends a decoded log entry that could not be stored to the
+// dead-letter producer, recording where it was consumed from and why it failed.
+func DeadLetterLog(dlq *Producer, msg LogMessage, cause string) error {
+	value, err := json.Marshal(msg.Entry)
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{
+		HeaderSourceTopic:     msg.Topic,
+		HeaderSourcePartition: strconv.FormatInt(int64(msg.Partition), 10),
+		HeaderSourceOffset:    strconv.FormatInt(msg.Offset, 10),
+		HeaderError:           cause,
+		HeaderFailedAt:        time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if err := dlq.Send("", nil, value, headers); err != nil {
+		deadLetterFailures.WithLabelValues(msg.Topic).Inc()
+		return err
+	}
+
+	deadLetterMessages.WithLabelValues(msg.Topic).Inc()
+	return nil
+}
+
+// MessageHeader returns the value of the named record header, if present.
+func MessageHeader(message *sarama.ConsumerMessage, key string) (string, bool) {
+	for _, header := range message.Headers {
+		if header != nil && string(header.Key) == key {
+			return string(header.Value), true
+		}
+	}
+	return "", false
+}
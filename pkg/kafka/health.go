@@ -0,0 +1,90 @@
+package kafka
+
+import (
+	"sync"
+	"time"
+)
+
+// Consumer states reported in HealthStatus.
+const (
+	HealthStarting = "starting"
+	HealthOK       = "ok"
+	HealthRetrying = "retrying"
+	HealthFailed   = "failed"
+)
+
+// HealthStatus describes the state of a Consumer. Failures counts the
+// consecutive failed attempts to consume since the last successful session.
+type HealthStatus struct {
+	Status      string    `json:"status"`
+	Since       time.Time `json:"since"`
+	Failures    int       `json:"failures"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at,omitempty"`
+}
+
+type health struct {
+	status HealthStatus
+	mutex  sync.Mutex
+}
+
+func newHealth() *health {
+	return &health{status: HealthStatus{Status: HealthStarting, Since: time.Now()}}
+}
+
+func (h *health) get() HealthStatus {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.status
+}
+
+// ok records that a session was established.
+func (h *health) ok() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.setLocked(HealthOK)
+	h.status.Failures = 0
+}
+
+// fail records a failed attempt to consume and returns the number of
+// consecutive failures and when the first of them happened.
+func (h *health) fail(err error) (int, time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.setLocked(HealthRetrying)
+	h.status.Failures++
+	h.errorLocked(err)
+	return h.status.Failures, h.status.Since
+}
+
+// giveUp records that the consumer stopped retrying.
+func (h *health) giveUp(err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.setLocked(HealthFailed)
+	h.errorLocked(err)
+}
+
+// record records an error that did not interrupt consumption.
+func (h *health) record(err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.errorLocked(err)
+}
+
+func (h *health) setLocked(status string) {
+	if h.status.Status != status {
+		h.status.Status = status
+		h.status.Since = time.Now()
+	}
+}
+
+func (h *health) errorLocked(err error) {
+	h.status.LastError = err.Error()
+	h.status.LastErrorAt = time.Now()
+}
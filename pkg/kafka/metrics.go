@@ -0,0 +1,134 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// lagInterval is how often the lag of the claimed partitions is measured.
+const lagInterval = 15 * time.Second
+
+var (
+	messagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_ingestion_messages_consumed_total",
+		Help: "Messages read from Kafka.",
+	}, []string{"topic"})
+
+	bytesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_ingestion_bytes_consumed_total",
+		Help: "Bytes of message values read from Kafka.",
+	}, []string{"topic"})
+
+	decodeFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_ingestion_decode_failures_total",
+		Help: "Messages that could not be decoded into a log entry.",
+	}, []string{"topic"})
+
+	consumerErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "log_ingestion_consumer_errors_total",
+		Help: "Errors reported by the Kafka consumer group while consuming.",
+	})
+
+	consumerRestarts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "log_ingestion_consumer_restarts_total",
+		Help: "Failed consumer group sessions that were retried.",
+	})
+
+	consumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "log_ingestion_consumer_lag",
+		Help: "Messages between the high watermark and the committed offset of each claimed partition.",
+	}, []string{"topic", "partition"})
+)
+
+// claims keeps the offset trackers of the partitions claimed in the current
+// session so their lag can be reported and their in-flight messages drained
+// when they are revoked.
+type claims struct {
+	trackers map[*offsetTracker]bool
+	mutex    sync.Mutex
+}
+
+func newClaims() *claims {
+	return &claims{trackers: make(map[*offsetTracker]bool)}
+}
+
+func (c *claims) add(tracker *offsetTracker) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.trackers[tracker] = true
+}
+
+// clear forgets the partitions of a session that ended and stops reporting
+// their lag.
+func (c *claims) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for tracker := range c.trackers {
+		delete(c.trackers, tracker)
+		consumerLag.DeleteLabelValues(tracker.topic, strconv.FormatInt(int64(tracker.partition), 10))
+	}
+}
+
+func (c *claims) list() []*offsetTracker {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	trackers := make([]*offsetTracker, 0, len(c.trackers))
+	for tracker := range c.trackers {
+		trackers = append(trackers, tracker)
+	}
+	return trackers
+}
+
+// reportLag measures the lag of the claimed partitions every lagInterval
+// until ctx is cancelled. The high watermark is fetched from the brokers
+// rather than taken from the claim, since the claim stops fetching while
+// the pipeline pushes back.
+func (c *Consumer) reportLag(ctx context.Context) {
+	ticker := time.NewTicker(lagInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, tracker := range c.handler.claims.list() {
+				lag, err := c.lag(tracker)
+				if err != nil {
+					c.logger.Warnf("Failed to measure lag of %s/%d: %v", tracker.topic, tracker.partition, err)
+					continue
+				}
+				consumerLag.WithLabelValues(tracker.topic, strconv.FormatInt(int64(tracker.partition), 10)).Set(float64(lag))
+			}
+		}
+	}
+}
+
+func (c *Consumer) lag(tracker *offsetTracker) (int64, error) {
+	highWatermark, err := c.client.GetOffset(tracker.topic, tracker.partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, err
+	}
+
+	// Without a committed offset the claim starts from the oldest message.
+	committed := tracker.committed()
+	if committed < 0 {
+		if committed, err = c.client.GetOffset(tracker.topic, tracker.partition, sarama.OffsetOldest); err != nil {
+			return 0, err
+		}
+	}
+
+	if lag := highWatermark - committed; lag > 0 {
+		return lag, nil
+	}
+	return 0, nil
+}
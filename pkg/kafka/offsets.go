@@ -0,0 +1,77 @@
+package kafka
+
+import (
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// offsetTracker commits the offsets of one claimed partition in order. Acks
+// may arrive in any order, but an offset is only marked once every earlier
+// message of the partition has been acked too, so a crash can never skip a
+// message that was not yet stored.
+type offsetTracker struct {
+	session   sarama.ConsumerGroupSession
+	topic     string
+	partition int32
+	next      int64
+	pending   []int64
+	acked     map[int64]bool
+	mutex     sync.Mutex
+}
+
+// newOffsetTracker creates a tracker for a partition whose committed offset
+// is initial, or sarama.OffsetOldest when nothing was committed yet.
+func newOffsetTracker(session sarama.ConsumerGroupSession, topic string, partition int32, initial int64) *offsetTracker {
+	return &offsetTracker{
+		session:   session,
+		topic:     topic,
+		partition: partition,
+		next:      initial,
+		acked:     make(map[int64]bool),
+	}
+}
+
+// track registers a delivered message. Messages must be tracked in the order
+// they are read from the partition.
+func (t *offsetTracker) track(offset int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.pending = append(t.pending, offset)
+}
+
+func (t *offsetTracker) ack(offset int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.acked[offset] = true
+
+	committed := int64(-1)
+	for len(t.pending) > 0 && t.acked[t.pending[0]] {
+		committed = t.pending[0]
+		delete(t.acked, committed)
+		t.pending = t.pending[1:]
+	}
+
+	if committed >= 0 {
+		t.next = committed + 1
+		t.session.MarkOffset(t.topic, t.partition, t.next, "")
+	}
+}
+
+// inflight returns the number of tracked messages that are not committed yet.
+func (t *offsetTracker) inflight() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return len(t.pending)
+}
+
+// committed returns the offset of the next message to commit.
+func (t *offsetTracker) committed() int64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.next
+}
@@ -0,0 +1,138 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/IBM/sarama"
+)
+
+type Producer struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+// ProducerOptions controls the delivery guarantees of a Producer.
+// RequiredAcks is "none", "leader" or "all" (the default). Idempotent
+// producers always wait for all replicas.
+type ProducerOptions struct {
+	RequiredAcks string
+	Idempotent   bool
+	MaxRetries   int
+	Security     SecurityConfig
+}
+
+func NewProducer(brokers []string, topic string, opts ProducerOptions) (*Producer, error) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
+	config.Producer.Partitioner = sarama.NewHashPartitioner
+
+	if err := opts.Security.apply(config); err != nil {
+		return nil, err
+	}
+
+	switch opts.RequiredAcks {
+	case "", "all":
+		config.Producer.RequiredAcks = sarama.WaitForAll
+	case "leader":
+		config.Producer.RequiredAcks = sarama.WaitForLocal
+	case "none":
+		config.Producer.RequiredAcks = sarama.NoResponse
+	default:
+		return nil, fmt.Errorf("unknown required_acks: %s", opts.RequiredAcks)
+	}
+
+	if opts.MaxRetries > 0 {
+		config.Producer.Retry.Max = opts.MaxRetries
+	}
+
+	if opts.Idempotent {
+		if config.Producer.RequiredAcks != sarama.WaitForAll {
+			return nil, fmt.Errorf("idempotent producer requires required_acks: all")
+		}
+		config.Producer.Idempotent = true
+		config.Net.MaxOpenRequests = 1
+	}
+
+	producer, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Producer{
+		producer: producer,
+		topic:    topic,
+	}, nil
+}
+
+// Publish encodes value as JSON and sends it keyed by key, so that messages
+// with the same key land on the same partition in order.
+func (p *Producer) Publish(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = p.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: p.topic,
+		Key:   sarama.StringEncoder(key),
+		Value: sarama.ByteEncoder(data),
+	})
+	return err
+}
+
+// Send produces a raw message with the given headers. An empty topic sends
+// to the producer's own topic.
+func (p *Producer) Send(topic string, key, value []byte, headers map[string]string) error {
+	if topic == "" {
+		topic = p.topic
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic: topic,
+		Value: sarama.ByteEncoder(value),
+	}
+	if key != nil {
+		msg.Key = sarama.ByteEncoder(key)
+	}
+	for k, v := range headers {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+
+	_, _, err := p.producer.SendMessage(msg)
+	return err
+}
+
+// Message is a record for SendBatch.
+type Message struct {
+	Key     []byte
+	Value   []byte
+	Headers map[string]string
+}
+
+// SendBatch produces messages to the producer's own topic, waiting until
+// every one of them was acknowledged. On error some messages may have been
+// delivered, so retrying the batch can produce duplicates.
+func (p *Producer) SendBatch(messages []Message) error {
+	batch := make([]*sarama.ProducerMessage, len(messages))
+	for i, message := range messages {
+		msg := &sarama.ProducerMessage{
+			Topic: p.topic,
+			Value: sarama.ByteEncoder(message.Value),
+		}
+		if message.Key != nil {
+			msg.Key = sarama.ByteEncoder(message.Key)
+		}
+		for k, v := range message.Headers {
+			msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+		}
+		batch[i] = msg
+	}
+
+	return p.producer.SendMessages(batch)
+}
+
+func (p *Producer) Close() error {
+	return p.producer.Close()
+}
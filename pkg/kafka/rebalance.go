@@ -0,0 +1,89 @@
+package kafka
+
+import (
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// drainPollInterval is how often Cleanup checks whether the revoked
+// partitions have been drained.
+const drainPollInterval = 100 * time.Millisecond
+
+// Partition identifies a Kafka partition.
+type Partition struct {
+	Topic     string
+	Partition int32
+}
+
+// Cleanup runs when the session ends, after every ConsumeClaim has returned
+// but before the marked offsets are committed and the partitions are handed
+// to other members. It sends a flush marker for the revoked partitions down
+// the pipeline and waits for their in-flight messages to be acked, so the
+// next owner resumes right after them instead of consuming them again.
+// Whatever is still in flight after the drain timeout, or when the consumer
+// is stopped, stays uncommitted and is redelivered.
+func (h *ConsumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	defer h.claims.clear()
+
+	trackers := h.claims.list()
+	if len(trackers) == 0 {
+		return nil
+	}
+
+	revoked := make([]Partition, len(trackers))
+	for i, tracker := range trackers {
+		revoked[i] = Partition{Topic: tracker.topic, Partition: tracker.partition}
+	}
+
+	timeout := time.NewTimer(h.drainTimeout)
+	defer timeout.Stop()
+
+	select {
+	case h.logChan <- NewFlushMarker(revoked):
+	case <-timeout.C:
+		h.logger.Warnf("Timed out flushing %d revoked partitions, their in-flight messages will be redelivered", len(revoked))
+		return nil
+	case <-h.done:
+		return nil
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		inflight := 0
+		for _, tracker := range trackers {
+			inflight += tracker.inflight()
+		}
+		if inflight == 0 {
+			h.logger.Infof("Flushed %d revoked partitions", len(revoked))
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-timeout.C:
+			h.logger.Warnf("Timed out flushing %d revoked partitions, %d in-flight messages will be redelivered", len(revoked), inflight)
+			return nil
+		case <-h.done:
+			return nil
+		}
+	}
+}
+
+// NewFlushMarker returns the flush marker Cleanup sends down the pipeline
+// for the revoked partitions.
+func NewFlushMarker(revoked []Partition) LogMessage {
+	return LogMessage{revoked: revoked}
+}
+
+// BelongsTo reports whether m was consumed from one of partitions.
+func (m LogMessage) BelongsTo(partitions []Partition) bool {
+	for _, p := range partitions {
+		if p.Topic == m.Topic && p.Partition == m.Partition {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,221 @@
+package kafka
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/sirupsen/logrus"
+
+	"awesomeProject6/pkg/parser"
+)
+
+// fakeSession records the offsets the handler marks.
+type fakeSession struct {
+	ctx    context.Context
+	mutex  sync.Mutex
+	marked []int64
+}
+
+func (s *fakeSession) Claims() map[string][]int32 { return nil }
+func (s *fakeSession) MemberID() string           { return "member" }
+func (s *fakeSession) GenerationID() int32        { return 1 }
+func (s *fakeSession) Commit()                    {}
+func (s *fakeSession) Context() context.Context   { return s.ctx }
+
+func (s *fakeSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.marked = append(s.marked, offset)
+}
+
+func (s *fakeSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {}
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+func (s *fakeSession) markedOffsets() []int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]int64(nil), s.marked...)
+}
+
+// fakeClaim serves the messages of a mock partition consumer.
+type fakeClaim struct {
+	sarama.PartitionConsumer
+	topic     string
+	partition int32
+}
+
+func (c *fakeClaim) Topic() string        { return c.topic }
+func (c *fakeClaim) Partition() int32     { return c.partition }
+func (c *fakeClaim) InitialOffset() int64 { return 0 }
+
+// rebalanceTest runs ConsumeClaim over three messages of logs/0 and ends the
+// session once they are in the pipeline, leaving them unacked.
+type rebalanceTest struct {
+	handler  *ConsumerGroupHandler
+	session  *fakeSession
+	consumer *mocks.Consumer
+	messages []LogMessage
+}
+
+func newRebalanceTest(t *testing.T, drainTimeout time.Duration) *rebalanceTest {
+	parsers, err := parser.NewRegistry("", parser.Defaults{})
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	subs, err := newSubscriptions([]Subscription{{Topic: "logs"}})
+	if err != nil {
+		t.Fatalf("newSubscriptions: %v", err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	rt := &rebalanceTest{
+		handler: &ConsumerGroupHandler{
+			logChan:       make(chan LogMessage),
+			subscriptions: subs,
+			parsers:       parsers,
+			claims:        newClaims(),
+			drainTimeout:  drainTimeout,
+			done:          make(chan struct{}),
+			health:        newHealth(),
+			logger:        logger,
+		},
+		consumer: mocks.NewConsumer(t, nil),
+	}
+
+	partition := rt.consumer.ExpectConsumePartition("logs", 0, sarama.OffsetOldest)
+	for i := 0; i < 3; i++ {
+		partition.YieldMessage(&sarama.ConsumerMessage{Value: []byte(`{"message":"hello","level":"INFO"}`)})
+	}
+	pc, err := rt.consumer.ConsumePartition("logs", 0, sarama.OffsetOldest)
+	if err != nil {
+		t.Fatalf("ConsumePartition: %v", err)
+	}
+	t.Cleanup(func() { rt.consumer.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rt.session = &fakeSession{ctx: ctx}
+
+	claimDone := make(chan error, 1)
+	go func() {
+		claimDone <- rt.handler.ConsumeClaim(rt.session, &fakeClaim{PartitionConsumer: pc, topic: "logs", partition: 0})
+	}()
+
+	for i := 0; i < 3; i++ {
+		rt.messages = append(rt.messages, rt.receive(t))
+	}
+
+	cancel()
+	if err := <-claimDone; err != nil {
+		t.Fatalf("ConsumeClaim: %v", err)
+	}
+	return rt
+}
+
+func (rt *rebalanceTest) receive(t *testing.T) LogMessage {
+	t.Helper()
+
+	select {
+	case msg := <-rt.handler.logChan:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a message")
+		return LogMessage{}
+	}
+}
+
+// cleanup starts Cleanup and returns a channel closed when it returns.
+func (rt *rebalanceTest) cleanup(t *testing.T) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := rt.handler.Cleanup(rt.session); err != nil {
+			t.Errorf("Cleanup: %v", err)
+		}
+	}()
+	return done
+}
+
+func returned(done <-chan struct{}, wait time.Duration) bool {
+	select {
+	case <-done:
+		return true
+	case <-time.After(wait):
+		return false
+	}
+}
+
+func TestCleanupWaitsForRevokedMessages(t *testing.T) {
+	rt := newRebalanceTest(t, 5*time.Second)
+	done := rt.cleanup(t)
+
+	marker := rt.receive(t)
+	want := []Partition{{Topic: "logs", Partition: 0}}
+	if !reflect.DeepEqual(marker.Revoked(), want) {
+		t.Fatalf("marker revoked %v, want %v", marker.Revoked(), want)
+	}
+
+	// Later offsets are acked first; nothing may be committed past the gap.
+	rt.messages[1].Ack()
+	rt.messages[2].Ack()
+	if returned(done, 3*drainPollInterval) {
+		t.Fatal("Cleanup returned while offset 0 was still in flight")
+	}
+	if marked := rt.session.markedOffsets(); len(marked) != 0 {
+		t.Fatalf("marked %v before offset 0 was acked", marked)
+	}
+
+	rt.messages[0].Ack()
+	if !returned(done, time.Second) {
+		t.Fatal("Cleanup did not return after every message was acked")
+	}
+	if marked := rt.session.markedOffsets(); !reflect.DeepEqual(marked, []int64{3}) {
+		t.Errorf("marked %v, want [3]", marked)
+	}
+}
+
+func TestCleanupGivesUpAfterDrainTimeout(t *testing.T) {
+	drainTimeout := 300 * time.Millisecond
+	rt := newRebalanceTest(t, drainTimeout)
+
+	start := time.Now()
+	done := rt.cleanup(t)
+	rt.receive(t)
+
+	rt.messages[0].Ack()
+	rt.messages[2].Ack()
+
+	if !returned(done, 2*time.Second) {
+		t.Fatal("Cleanup did not give up after the drain timeout")
+	}
+	if elapsed := time.Since(start); elapsed < drainTimeout {
+		t.Errorf("Cleanup returned after %s, before the %s drain timeout", elapsed, drainTimeout)
+	}
+	if marked := rt.session.markedOffsets(); !reflect.DeepEqual(marked, []int64{1}) {
+		t.Errorf("marked %v, want only the contiguous offset [1]", marked)
+	}
+}
+
+func TestCleanupGivesUpWhenMarkerIsNotAccepted(t *testing.T) {
+	rt := newRebalanceTest(t, 200*time.Millisecond)
+
+	// Nothing reads the pipeline, so the marker can never be sent.
+	if !returned(rt.cleanup(t), 2*time.Second) {
+		t.Fatal("Cleanup did not give up sending the flush marker")
+	}
+	if marked := rt.session.markedOffsets(); len(marked) != 0 {
+		t.Errorf("marked %v, want nothing", marked)
+	}
+}
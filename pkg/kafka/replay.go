@@ -0,0 +1,162 @@
+package kafka
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/IBM/sarama"
+)
+
+// DeadLetterReplayer copies messages from a dead-letter topic back to the
+// topic they originally came from. Progress is committed under its own
+// consumer group so that repeated runs only replay new dead letters.
+type DeadLetterReplayer struct {
+	client   sarama.Client
+	offsets  sarama.OffsetManager
+	consumer sarama.Consumer
+	producer *Producer
+	topic    string
+}
+
+func NewDeadLetterReplayer(brokers []string, security SecurityConfig, groupID, dlqTopic string, producer *Producer) (*DeadLetterReplayer, error) {
+	config := sarama.NewConfig()
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	if err := security.apply(config); err != nil {
+		return nil, err
+	}
+
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets, err := sarama.NewOffsetManagerFromClient(groupID, client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		offsets.Close()
+		client.Close()
+		return nil, err
+	}
+
+	return &DeadLetterReplayer{
+		client:   client,
+		offsets:  offsets,
+		consumer: consumer,
+		producer: producer,
+		topic:    dlqTopic,
+	}, nil
+}
+
+// Replay sends every dead letter not yet replayed to target, or to the
+// message's source topic when target is empty. It stops once it has caught
+// up with the end of each partition as seen when Replay started, or after
+// limit messages when limit is positive.
+func (r *DeadLetterReplayer) Replay(target string, limit int) (int, error) {
+	partitions, err := r.client.Partitions(r.topic)
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for _, partition := range partitions {
+		remaining := 0
+		if limit > 0 {
+			remaining = limit - replayed
+			if remaining <= 0 {
+				break
+			}
+		}
+
+		n, err := r.replayPartition(partition, target, remaining)
+		replayed += n
+		if err != nil {
+			return replayed, err
+		}
+	}
+
+	return replayed, nil
+}
+
+func (r *DeadLetterReplayer) replayPartition(partition int32, target string, limit int) (int, error) {
+	pom, err := r.offsets.ManagePartition(r.topic, partition)
+	if err != nil {
+		return 0, err
+	}
+	defer pom.Close()
+
+	oldest, err := r.client.GetOffset(r.topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, err
+	}
+	end, err := r.client.GetOffset(r.topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, err
+	}
+
+	start, _ := pom.NextOffset()
+	if start < oldest {
+		start = oldest
+	}
+	if start >= end {
+		return 0, nil
+	}
+
+	pc, err := r.consumer.ConsumePartition(r.topic, partition, start)
+	if err != nil {
+		return 0, err
+	}
+	defer pc.Close()
+
+	replayed := 0
+	for {
+		select {
+		case message := <-pc.Messages():
+			destination := target
+			if destination == "" {
+				destination, _ = MessageHeader(message, HeaderSourceTopic)
+			}
+			if destination == "" {
+				return replayed, fmt.Errorf("message at %s/%d/%d has no source topic", r.topic, partition, message.Offset)
+			}
+
+			if err := r.producer.Send(destination, message.Key, message.Value, originalHeaders(message)); err != nil {
+				return replayed, err
+			}
+
+			pom.MarkOffset(message.Offset+1, "")
+			deadLetterReplayed.WithLabelValues(destination).Inc()
+			replayed++
+
+			if message.Offset+1 >= end || (limit > 0 && replayed >= limit) {
+				return replayed, nil
+			}
+
+		case err := <-pc.Errors():
+			return replayed, err
+		}
+	}
+}
+
+func (r *DeadLetterReplayer) Close() error {
+	r.consumer.Close()
+	r.offsets.Close()
+	return r.client.Close()
+}
+
+// originalHeaders returns the headers of a dead letter without the metadata
+// added when it was forwarded.
+func originalHeaders(message *sarama.ConsumerMessage) map[string]string {
+	headers := make(map[string]string)
+	for _, header := range message.Headers {
+		if header != nil && !strings.HasPrefix(string(header.Key), "dlq.") {
+			headers[string(header.Key)] = string(header.Value)
+		}
+	}
+	return headers
+}
@@ -0,0 +1,35 @@
+package kafka
+
+import (
+	"github.com/xdg-go/scram"
+)
+
+// scramClient adapts an xdg-go/scram conversation to sarama's SCRAMClient.
+// The library normalises usernames and passwords with SASLprep and verifies
+// the server signature.
+type scramClient struct {
+	hash         scram.HashGeneratorFcn
+	conversation *scram.ClientConversation
+}
+
+func newSCRAMClient(hash scram.HashGeneratorFcn) *scramClient {
+	return &scramClient{hash: hash}
+}
+
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.hash.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+
+	c.conversation = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
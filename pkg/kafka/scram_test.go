@@ -0,0 +1,84 @@
+package kafka
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/xdg-go/scram"
+)
+
+// authenticate logs in as username with password against a server that
+// stores the credentials derived from stored, driving the scramClient the way
+// sarama does.
+func authenticate(hash scram.HashGeneratorFcn, stored, username, password string) error {
+	storedClient, err := hash.NewClient(username, stored, "")
+	if err != nil {
+		return err
+	}
+	credentials := storedClient.GetStoredCredentials(scram.KeyFactors{Salt: "salt", Iters: 4096})
+
+	server, err := hash.NewServer(func(name string) (scram.StoredCredentials, error) {
+		if name != username {
+			return scram.StoredCredentials{}, fmt.Errorf("unknown user %s", name)
+		}
+		return credentials, nil
+	})
+	if err != nil {
+		return err
+	}
+	serverConv := server.NewConversation()
+
+	client := newSCRAMClient(hash)
+	if err := client.Begin(username, password, ""); err != nil {
+		return err
+	}
+
+	challenge := ""
+	for !client.Done() {
+		response, err := client.Step(challenge)
+		if err != nil {
+			return err
+		}
+		if client.Done() {
+			break
+		}
+		challenge, err = serverConv.Step(response)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !serverConv.Valid() {
+		return fmt.Errorf("server did not accept the client")
+	}
+	return nil
+}
+
+func TestSCRAMClient(t *testing.T) {
+	hashes := map[string]scram.HashGeneratorFcn{
+		SASLSCRAMSHA256: scram.SHA256,
+		SASLSCRAMSHA512: scram.SHA512,
+	}
+
+	tests := []struct {
+		name     string
+		stored   string
+		password string
+		wantErr  bool
+	}{
+		{name: "correct password", stored: "pencil", password: "pencil"},
+		{name: "wrong password", stored: "pencil", password: "pen", wantErr: true},
+		{name: "saslprep removes soft hyphen", stored: "IX", password: "I\u00adX"},
+	}
+
+	for mechanism, hash := range hashes {
+		for _, tt := range tests {
+			t.Run(mechanism+"/"+tt.name, func(t *testing.T) {
+				err := authenticate(hash, tt.stored, "alice", tt.password)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("authenticate() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// SASL mechanisms accepted in SASLConfig.Mechanism.
+const (
+	SASLPlain       = "PLAIN"
+	SASLSCRAMSHA256 = "SCRAM-SHA-256"
+	SASLSCRAMSHA512 = "SCRAM-SHA-512"
+)
+
+// SecurityConfig holds the connection security settings shared by every
+// consumer and producer. The zero value connects in plaintext without
+// authentication.
+type SecurityConfig struct {
+	TLS  TLSConfig
+	SASL SASLConfig
+}
+
+// TLSConfig enables TLS to the brokers. CAFile verifies the brokers instead
+// of the system roots; CertFile and KeyFile present a client certificate for
+// mutual TLS.
+type TLSConfig struct {
+	Enabled            bool
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+}
+
+// SASLConfig enables SASL authentication when Mechanism is set.
+type SASLConfig struct {
+	Mechanism string
+	Username  string
+	Password  string
+}
+
+// apply configures TLS and SASL on a sarama config.
+func (s SecurityConfig) apply(config *sarama.Config) error {
+	if s.TLS.Enabled {
+		tlsConfig, err := s.TLS.build()
+		if err != nil {
+			return err
+		}
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	if s.SASL.Mechanism == "" {
+		return nil
+	}
+
+	config.Net.SASL.Enable = true
+	config.Net.SASL.User = s.SASL.Username
+	config.Net.SASL.Password = s.SASL.Password
+
+	switch strings.ToUpper(s.SASL.Mechanism) {
+	case SASLPlain:
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case SASLSCRAMSHA256:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return newSCRAMClient(scram.SHA256)
+		}
+	case SASLSCRAMSHA512:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return newSCRAMClient(scram.SHA512)
+		}
+	default:
+		return fmt.Errorf("unsupported SASL mechanism: %s", s.SASL.Mechanism)
+	}
+
+	return nil
+}
+
+func (t TLSConfig) build() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", t.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		if t.CertFile == "" || t.KeyFile == "" {
+			return nil, fmt.Errorf("client certificate requires both cert_file and key_file")
+		}
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
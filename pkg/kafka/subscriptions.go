@@ -0,0 +1,117 @@
+package kafka
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Subscription selects topics to consume, either one Topic by name or every
+// topic whose name matches Pattern, and the settings for their messages:
+// Format names the parser (the registry's default when empty), Index the
+// index prefix to write to (the configured index when empty) and Service the
+// service for entries that do not name one (the topic name when empty).
+type Subscription struct {
+	Topic   string
+	Pattern string
+	Format  string
+	Index   string
+	Service string
+}
+
+type subscription struct {
+	Subscription
+	re *regexp.Regexp
+}
+
+// subscriptions matches topics against the configured subscriptions.
+// Subscriptions naming a topic take precedence over patterns; among
+// patterns the first match wins.
+type subscriptions struct {
+	topics   map[string]*subscription
+	patterns []*subscription
+}
+
+func newSubscriptions(subs []Subscription) (*subscriptions, error) {
+	if len(subs) == 0 {
+		return nil, fmt.Errorf("no topic subscriptions configured")
+	}
+
+	s := &subscriptions{topics: make(map[string]*subscription)}
+	for i, sub := range subs {
+		switch {
+		case sub.Topic != "" && sub.Pattern != "":
+			return nil, fmt.Errorf("subscription %d sets both topic and pattern", i)
+		case sub.Topic != "":
+			if _, ok := s.topics[sub.Topic]; ok {
+				return nil, fmt.Errorf("topic %s is subscribed more than once", sub.Topic)
+			}
+			s.topics[sub.Topic] = &subscription{Subscription: sub}
+		case sub.Pattern != "":
+			re, err := regexp.Compile(sub.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("subscription %d has an invalid pattern: %v", i, err)
+			}
+			s.patterns = append(s.patterns, &subscription{Subscription: sub, re: re})
+		default:
+			return nil, fmt.Errorf("subscription %d needs a topic or a pattern", i)
+		}
+	}
+	return s, nil
+}
+
+func (s *subscriptions) hasPatterns() bool {
+	return len(s.patterns) > 0
+}
+
+// match returns the subscription covering topic.
+func (s *subscriptions) match(topic string) (*subscription, bool) {
+	if sub, ok := s.topics[topic]; ok {
+		return sub, true
+	}
+	for _, sub := range s.patterns {
+		if sub.re.MatchString(topic) {
+			return sub, true
+		}
+	}
+	return nil, false
+}
+
+// resolve returns the sorted names of the topics to consume, given the
+// topics that exist in the cluster. Named topics are always included so the
+// consumer picks them up as soon as they are created. Internal topics are
+// never matched by patterns.
+func (s *subscriptions) resolve(available []string) []string {
+	set := make(map[string]bool)
+	for topic := range s.topics {
+		set[topic] = true
+	}
+	for _, topic := range available {
+		if strings.HasPrefix(topic, "__") {
+			continue
+		}
+		if _, ok := s.match(topic); ok {
+			set[topic] = true
+		}
+	}
+
+	topics := make([]string, 0, len(set))
+	for topic := range set {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+func sameTopics(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
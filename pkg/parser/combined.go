@@ -0,0 +1,80 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"awesomeProject6/internal/models"
+)
+
+// combinedPattern matches the Apache/Nginx combined log format and, with the
+// referrer and user agent missing, the common log format.
+var combinedPattern = regexp.MustCompile(
+	`^(\S+) (\S+) (\S+) \[([^\]]+)\] "((?:[^"\\]|\\.)*)" (\d{3}) (\d+|-)(?: "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)")?`,
+)
+
+const combinedTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// parseCombined parses an access log line. The request, status, size,
+// referrer and user agent are stored in Fields, and the level is derived from
+// the status: 5xx is ERROR, 4xx is WARN and anything else INFO.
+func parseCombined(data []byte) (models.LogEntry, error) {
+	line := strings.TrimRight(string(data), "\r\n")
+
+	match := combinedPattern.FindStringSubmatch(line)
+	if match == nil {
+		return models.LogEntry{}, fmt.Errorf("not a combined or common log line")
+	}
+
+	ts, err := time.Parse(combinedTimeLayout, match[4])
+	if err != nil {
+		return models.LogEntry{}, fmt.Errorf("invalid timestamp %q", match[4])
+	}
+
+	status, _ := strconv.Atoi(match[6])
+	fields := map[string]interface{}{
+		"client_ip": match[1],
+		"status":    status,
+	}
+	if match[3] != "-" {
+		fields["user"] = match[3]
+	}
+	if match[7] != "-" {
+		size, _ := strconv.ParseInt(match[7], 10, 64)
+		fields["bytes"] = size
+	}
+
+	request := strings.Fields(match[5])
+	if len(request) == 3 {
+		fields["method"] = request[0]
+		fields["path"] = request[1]
+		fields["protocol"] = request[2]
+	} else {
+		fields["request"] = match[5]
+	}
+
+	if match[8] != "" && match[8] != "-" {
+		fields["referrer"] = match[8]
+	}
+	if match[9] != "" && match[9] != "-" {
+		fields["user_agent"] = match[9]
+	}
+
+	level := "INFO"
+	switch {
+	case status >= 500:
+		level = "ERROR"
+	case status >= 400:
+		level = "WARN"
+	}
+
+	return models.LogEntry{
+		Timestamp: ts,
+		Level:     level,
+		Message:   line,
+		Fields:    fields,
+	}, nil
+}
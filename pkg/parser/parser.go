@@ -0,0 +1,202 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"awesomeProject6/internal/models"
+)
+
+// Format names understood by the default registry. FormatHeader is the Kafka
+// record header producers can set to choose the format of a single message.
+const (
+	FormatJSON     = "json"
+	FormatText     = "text"
+	FormatLogfmt   = "logfmt"
+	FormatSyslog   = "syslog"
+	FormatCombined = "combined"
+
+	FormatHeader = "log.format"
+)
+
+// Parser converts a raw log payload into a LogEntry. Parsers only fill in what
+// the payload contains; the Registry applies defaults for the rest.
+type Parser interface {
+	Parse(data []byte) (models.LogEntry, error)
+}
+
+// ParserFunc adapts a function to the Parser interface.
+type ParserFunc func(data []byte) (models.LogEntry, error)
+
+func (f ParserFunc) Parse(data []byte) (models.LogEntry, error) {
+	return f(data)
+}
+
+// Defaults fill in fields a payload did not provide.
+type Defaults struct {
+	Service string
+	Host    string
+	Level   string
+}
+
+// Registry holds the parsers by format name, along with the format and
+// defaults used when a source does not specify its own.
+type Registry struct {
+	parsers       map[string]Parser
+	defaultFormat string
+	defaults      Defaults
+}
+
+// NewRegistry creates a registry with the built-in parsers. "apache" and
+// "nginx" are aliases for the combined access log format.
+func NewRegistry(defaultFormat string, defaults Defaults) (*Registry, error) {
+	if defaultFormat == "" {
+		defaultFormat = FormatJSON
+	}
+	if defaults.Host == "" {
+		defaults.Host = "unknown"
+	}
+	if defaults.Level == "" {
+		defaults.Level = "INFO"
+	}
+
+	r := &Registry{
+		parsers:       make(map[string]Parser),
+		defaultFormat: defaultFormat,
+		defaults:      defaults,
+	}
+
+	r.Register(FormatJSON, ParserFunc(parseJSON))
+	r.Register(FormatText, ParserFunc(parseText))
+	r.Register(FormatLogfmt, ParserFunc(parseLogfmt))
+	r.Register(FormatSyslog, ParserFunc(ParseSyslog))
+	r.Register(FormatCombined, ParserFunc(parseCombined))
+	r.Register("apache", ParserFunc(parseCombined))
+	r.Register("nginx", ParserFunc(parseCombined))
+
+	if !r.Has(defaultFormat) {
+		return nil, fmt.Errorf("unknown default log format: %s", defaultFormat)
+	}
+
+	return r, nil
+}
+
+// Register adds or replaces the parser for a format name.
+func (r *Registry) Register(name string, parser Parser) {
+	r.parsers[name] = parser
+}
+
+// Has reports whether a parser is registered for format.
+func (r *Registry) Has(format string) bool {
+	_, ok := r.parsers[strings.ToLower(format)]
+	return ok
+}
+
+// Formats returns the registered format names.
+func (r *Registry) Formats() []string {
+	formats := make([]string, 0, len(r.parsers))
+	for name := range r.parsers {
+		formats = append(formats, name)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// Parse decodes data with the named format, or the default format when
+// format is empty. A missing service defaults to service, or to the
+// registry's default service when that is empty too. Timestamps missing from
+// the payload default to received, or to the current time when that is zero.
+func (r *Registry) Parse(format string, data []byte, service string, received time.Time) (models.LogEntry, error) {
+	if format == "" {
+		format = r.defaultFormat
+	}
+	format = strings.ToLower(format)
+
+	parser, ok := r.parsers[format]
+	if !ok {
+		return models.LogEntry{}, fmt.Errorf("unknown log format: %s", format)
+	}
+
+	entry, err := parser.Parse(data)
+	if err != nil {
+		return models.LogEntry{}, fmt.Errorf("failed to parse %s log: %v", format, err)
+	}
+
+	r.ApplyDefaults(&entry, service, received)
+	return entry, nil
+}
+
+// ApplyDefaults fills in what entry is missing the same way Parse does, for
+// sources that parse entries themselves.
+func (r *Registry) ApplyDefaults(entry *models.LogEntry, service string, received time.Time) {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = received
+		if entry.Timestamp.IsZero() {
+			entry.Timestamp = time.Now()
+		}
+	}
+	if entry.Level == "" {
+		entry.Level = r.defaults.Level
+	}
+	if entry.Service == "" {
+		entry.Service = service
+		if entry.Service == "" {
+			entry.Service = r.defaults.Service
+		}
+	}
+	if entry.Host == "" {
+		entry.Host = r.defaults.Host
+	}
+}
+
+func parseJSON(data []byte) (models.LogEntry, error) {
+	var entry models.LogEntry
+	err := json.Unmarshal(data, &entry)
+	return entry, err
+}
+
+// levelWords maps the level spellings commonly found in log lines to the
+// upper-case names used in stored entries.
+var levelWords = map[string]string{
+	"TRACE":    "TRACE",
+	"DEBUG":    "DEBUG",
+	"INFO":     "INFO",
+	"NOTICE":   "INFO",
+	"WARN":     "WARN",
+	"WARNING":  "WARN",
+	"ERROR":    "ERROR",
+	"ERR":      "ERROR",
+	"CRIT":     "FATAL",
+	"CRITICAL": "FATAL",
+	"FATAL":    "FATAL",
+	"PANIC":    "FATAL",
+}
+
+// NormalizeLevel maps a level spelling such as "warning", "err" or "crit" to
+// the upper-case name used in stored entries. It reports false for words it
+// does not recognise.
+func NormalizeLevel(word string) (string, bool) {
+	level, ok := levelWords[strings.ToUpper(word)]
+	return level, ok
+}
+
+// detectLevel looks for a level keyword among the first few words of a
+// message, such as "ERROR", "[warn]" or "level=info".
+func detectLevel(message string) string {
+	words := strings.Fields(message)
+	if len(words) > 5 {
+		words = words[:5]
+	}
+
+	for _, word := range words {
+		word = strings.TrimPrefix(word, "level=")
+		word = strings.Trim(word, "[]():,|")
+		if level, ok := NormalizeLevel(word); ok {
+			return level
+		}
+	}
+	return ""
+}
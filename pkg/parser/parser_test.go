@@ -0,0 +1,306 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"awesomeProject6/internal/models"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		input   string
+		want    models.LogEntry
+		wantErr bool
+	}{
+		{
+			name:   "rfc 5424 with structured data",
+			format: FormatSyslog,
+			input:  `<165>1 2024-08-16T10:20:30.5Z web01 api 1234 ID47 [exampleSDID@32473 iut="3" eventSource="App\"lication"][meta seq="7"] user logged in`,
+			want: models.LogEntry{
+				Timestamp: time.Date(2024, 8, 16, 10, 20, 30, 500000000, time.UTC),
+				Level:     "INFO",
+				Message:   "user logged in",
+				Service:   "api",
+				Host:      "web01",
+				Fields: map[string]interface{}{
+					"facility":                      "local4",
+					"severity":                      "notice",
+					"proc_id":                       "1234",
+					"msg_id":                        "ID47",
+					"exampleSDID@32473.iut":         "3",
+					"exampleSDID@32473.eventSource": `App"lication`,
+					"meta.seq":                      "7",
+				},
+			},
+		},
+		{
+			name:   "rfc 5424 with nil values",
+			format: FormatSyslog,
+			input:  "<11>1 - - - - - - disk failed",
+			want: models.LogEntry{
+				Level:   "ERROR",
+				Message: "disk failed",
+				Fields: map[string]interface{}{
+					"facility": "user",
+					"severity": "err",
+				},
+			},
+		},
+		{
+			name:   "rfc 5424 without message",
+			format: FormatSyslog,
+			input:  "<14>1 2024-08-16T10:20:30Z host app - - -",
+			want: models.LogEntry{
+				Timestamp: time.Date(2024, 8, 16, 10, 20, 30, 0, time.UTC),
+				Level:     "INFO",
+				Service:   "app",
+				Host:      "host",
+				Fields: map[string]interface{}{
+					"facility": "user",
+					"severity": "info",
+				},
+			},
+		},
+		{
+			name:   "rfc 3164 with rfc 3339 timestamp",
+			format: FormatSyslog,
+			input:  "<28>2024-08-16T10:20:30Z web01 sshd[42]: connection closed",
+			want: models.LogEntry{
+				Timestamp: time.Date(2024, 8, 16, 10, 20, 30, 0, time.UTC),
+				Level:     "WARN",
+				Message:   "connection closed",
+				Service:   "sshd",
+				Host:      "web01",
+				Fields: map[string]interface{}{
+					"facility": "daemon",
+					"severity": "warning",
+					"proc_id":  "42",
+				},
+			},
+		},
+		{
+			name:    "syslog without priority",
+			format:  FormatSyslog,
+			input:   "Aug 16 10:20:30 web01 sshd: hello",
+			wantErr: true,
+		},
+		{
+			name:    "syslog priority out of range",
+			format:  FormatSyslog,
+			input:   "<192>1 - - - - - -",
+			wantErr: true,
+		},
+		{
+			name:    "rfc 5424 truncated header",
+			format:  FormatSyslog,
+			input:   "<14>1 2024-08-16T10:20:30Z host",
+			wantErr: true,
+		},
+		{
+			name:    "rfc 5424 unterminated structured data",
+			format:  FormatSyslog,
+			input:   `<14>1 - - - - - [id key="value`,
+			wantErr: true,
+		},
+		{
+			name:   "combined access log",
+			format: FormatCombined,
+			input:  `10.0.0.1 - frank [16/Aug/2024:10:20:30 +0200] "GET /index.html HTTP/1.1" 404 512 "https://example.com/" "curl/8.0"`,
+			want: models.LogEntry{
+				Timestamp: time.Date(2024, 8, 16, 10, 20, 30, 0, time.FixedZone("", 2*60*60)),
+				Level:     "WARN",
+				Message:   `10.0.0.1 - frank [16/Aug/2024:10:20:30 +0200] "GET /index.html HTTP/1.1" 404 512 "https://example.com/" "curl/8.0"`,
+				Fields: map[string]interface{}{
+					"client_ip":  "10.0.0.1",
+					"user":       "frank",
+					"status":     404,
+					"bytes":      int64(512),
+					"method":     "GET",
+					"path":       "/index.html",
+					"protocol":   "HTTP/1.1",
+					"referrer":   "https://example.com/",
+					"user_agent": "curl/8.0",
+				},
+			},
+		},
+		{
+			name:   "common access log without size",
+			format: FormatCombined,
+			input:  `10.0.0.2 - - [16/Aug/2024:10:20:30 +0000] "BAD" 502 -`,
+			want: models.LogEntry{
+				Timestamp: time.Date(2024, 8, 16, 10, 20, 30, 0, time.FixedZone("", 0)),
+				Level:     "ERROR",
+				Message:   `10.0.0.2 - - [16/Aug/2024:10:20:30 +0000] "BAD" 502 -`,
+				Fields: map[string]interface{}{
+					"client_ip": "10.0.0.2",
+					"status":    502,
+					"request":   "BAD",
+				},
+			},
+		},
+		{
+			name:    "access log with bad timestamp",
+			format:  FormatCombined,
+			input:   `10.0.0.1 - - [yesterday] "GET / HTTP/1.1" 200 1`,
+			wantErr: true,
+		},
+		{
+			name:    "not an access log",
+			format:  FormatCombined,
+			input:   "GET / 200",
+			wantErr: true,
+		},
+		{
+			name:   "logfmt",
+			format: FormatLogfmt,
+			input:  `ts=2024-08-16T10:20:30Z level=warning msg="disk \"sda\" almost full" app=storage host=db01 used=93 bare`,
+			want: models.LogEntry{
+				Timestamp: time.Date(2024, 8, 16, 10, 20, 30, 0, time.UTC),
+				Level:     "WARN",
+				Message:   `disk "sda" almost full`,
+				Service:   "storage",
+				Host:      "db01",
+				Fields: map[string]interface{}{
+					"used": "93",
+					"bare": "",
+				},
+			},
+		},
+		{
+			name:    "logfmt of plain text",
+			format:  FormatLogfmt,
+			input:   "server started on port 8080",
+			wantErr: true,
+		},
+		{
+			name:    "logfmt unterminated quote",
+			format:  FormatLogfmt,
+			input:   `msg="no end`,
+			wantErr: true,
+		},
+		{
+			name:    "logfmt empty key",
+			format:  FormatLogfmt,
+			input:   "=value",
+			wantErr: true,
+		},
+		{
+			name:   "text with timestamp and level",
+			format: FormatText,
+			input:  "2024-08-16 10:20:30 [ERROR] payment failed\n",
+			want: models.LogEntry{
+				Timestamp: time.Date(2024, 8, 16, 10, 20, 30, 0, time.UTC),
+				Level:     "ERROR",
+				Message:   "[ERROR] payment failed",
+			},
+		},
+		{
+			name:    "empty text",
+			format:  FormatText,
+			input:   " \n",
+			wantErr: true,
+		},
+		{
+			name:    "malformed json",
+			format:  FormatJSON,
+			input:   `{"message": `,
+			wantErr: true,
+		},
+	}
+
+	r, err := NewRegistry("", Defaults{})
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.parsers[tt.format].Parse([]byte(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !got.Timestamp.Equal(tt.want.Timestamp) {
+				t.Errorf("Parse() timestamp = %v, want %v", got.Timestamp, tt.want.Timestamp)
+			}
+			got.Timestamp, tt.want.Timestamp = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRFC3164Year(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name  string
+		input string
+		want  time.Time
+	}{
+		{
+			name:  "earlier this year",
+			input: "Jan  1 09:00:00 web01 cron: job started",
+			want:  time.Date(2024, 1, 1, 9, 0, 0, 0, time.Local),
+		},
+		{
+			name:  "end of last year",
+			input: "Dec 31 23:59:59 web01 cron: job started",
+			want:  time.Date(2023, 12, 31, 23, 59, 59, 0, time.Local),
+		},
+		{
+			name:  "slightly ahead of the clock",
+			input: "Jan  1 12:00:00 web01 cron: job started",
+			want:  time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRFC3164(tt.input, now)
+			if err != nil {
+				t.Fatalf("parseRFC3164() error = %v", err)
+			}
+			if !got.Timestamp.Equal(tt.want) {
+				t.Errorf("parseRFC3164() timestamp = %v, want %v", got.Timestamp, tt.want)
+			}
+			if got.Host != "web01" || got.Service != "cron" || got.Message != "job started" {
+				t.Errorf("parseRFC3164() = %+v, want host web01, service cron and message %q", got, "job started")
+			}
+		})
+	}
+}
+
+func TestRegistryFallsBackToDefaults(t *testing.T) {
+	r, err := NewRegistry(FormatLogfmt, Defaults{Service: "fallback", Level: "DEBUG"})
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	received := time.Date(2024, 8, 16, 10, 0, 0, 0, time.UTC)
+
+	entry, err := r.Parse("", []byte("msg=hello"), "", received)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := models.LogEntry{
+		Timestamp: received,
+		Level:     "DEBUG",
+		Message:   "hello",
+		Service:   "fallback",
+		Host:      "unknown",
+	}
+	if !reflect.DeepEqual(entry, want) {
+		t.Errorf("Parse() = %+v, want %+v", entry, want)
+	}
+
+	if _, err := r.Parse("xml", []byte("<log/>"), "", received); err == nil {
+		t.Error("Parse() with an unknown format succeeded")
+	}
+}
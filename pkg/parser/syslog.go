@@ -0,0 +1,243 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"awesomeProject6/internal/models"
+)
+
+const nilValue = "-"
+
+var facilityNames = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var severityNames = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// severityLevels maps syslog severities onto entry levels.
+var severityLevels = []string{
+	"FATAL", "FATAL", "FATAL", "ERROR", "WARN", "INFO", "INFO", "DEBUG",
+}
+
+// ParseSyslog parses an RFC 5424 or RFC 3164 (BSD) syslog message, choosing
+// the format from the version field that follows the priority. The facility,
+// severity and any structured data are stored in Fields.
+func ParseSyslog(data []byte) (models.LogEntry, error) {
+	line := strings.TrimRight(string(data), "\r\n\x00")
+
+	priority, rest, err := parsePriority(line)
+	if err != nil {
+		return models.LogEntry{}, err
+	}
+
+	var entry models.LogEntry
+	if strings.HasPrefix(rest, "1 ") {
+		entry, err = parseRFC5424(rest[2:])
+	} else {
+		entry, err = parseRFC3164(rest, time.Now())
+	}
+	if err != nil {
+		return models.LogEntry{}, err
+	}
+
+	facility, severity := priority/8, priority%8
+	entry.Level = severityLevels[severity]
+	if entry.Fields == nil {
+		entry.Fields = make(map[string]interface{})
+	}
+	if facility < len(facilityNames) {
+		entry.Fields["facility"] = facilityNames[facility]
+	}
+	entry.Fields["severity"] = severityNames[severity]
+
+	return entry, nil
+}
+
+func parsePriority(line string) (int, string, error) {
+	if !strings.HasPrefix(line, "<") {
+		return 0, "", fmt.Errorf("missing priority")
+	}
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return 0, "", fmt.Errorf("invalid priority")
+	}
+
+	priority, err := strconv.Atoi(line[1:end])
+	if err != nil || priority < 0 || priority > 191 {
+		return 0, "", fmt.Errorf("invalid priority %q", line[1:end])
+	}
+	return priority, line[end+1:], nil
+}
+
+// parseRFC5424 parses the part of an RFC 5424 message after the version:
+// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG].
+func parseRFC5424(rest string) (models.LogEntry, error) {
+	var entry models.LogEntry
+
+	header := make([]string, 5)
+	for i := range header {
+		var ok bool
+		header[i], rest, ok = nextField(rest)
+		if !ok {
+			return entry, fmt.Errorf("truncated RFC 5424 header")
+		}
+	}
+
+	if header[0] != nilValue {
+		ts, err := time.Parse(time.RFC3339Nano, header[0])
+		if err != nil {
+			return entry, fmt.Errorf("invalid timestamp %q", header[0])
+		}
+		entry.Timestamp = ts
+	}
+	if header[1] != nilValue {
+		entry.Host = header[1]
+	}
+	if header[2] != nilValue {
+		entry.Service = header[2]
+	}
+
+	entry.Fields = make(map[string]interface{})
+	if header[3] != nilValue {
+		entry.Fields["proc_id"] = header[3]
+	}
+	if header[4] != nilValue {
+		entry.Fields["msg_id"] = header[4]
+	}
+
+	rest, err := parseStructuredData(rest, entry.Fields)
+	if err != nil {
+		return entry, err
+	}
+
+	message := strings.TrimPrefix(rest, " ")
+	entry.Message = strings.TrimPrefix(message, "\ufeff")
+	return entry, nil
+}
+
+// parseStructuredData reads the STRUCTURED-DATA element, storing each
+// parameter as "<sd-id>.<name>" in fields, and returns what follows it.
+func parseStructuredData(rest string, fields map[string]interface{}) (string, error) {
+	if strings.HasPrefix(rest, nilValue) {
+		return rest[1:], nil
+	}
+
+	for strings.HasPrefix(rest, "[") {
+		end := strings.IndexAny(rest, " ]")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated structured data")
+		}
+		id := rest[1:end]
+		rest = rest[end:]
+
+		for {
+			rest = strings.TrimLeft(rest, " ")
+			if strings.HasPrefix(rest, "]") {
+				rest = rest[1:]
+				break
+			}
+
+			eq := strings.Index(rest, "=\"")
+			if eq < 0 {
+				return "", fmt.Errorf("invalid structured data parameter in %s", id)
+			}
+			name := rest[:eq]
+			rest = rest[eq+2:]
+
+			var value strings.Builder
+			closed := false
+			for i := 0; i < len(rest); i++ {
+				c := rest[i]
+				if c == '\\' && i+1 < len(rest) && strings.IndexByte(`"\]`, rest[i+1]) >= 0 {
+					value.WriteByte(rest[i+1])
+					i++
+					continue
+				}
+				if c == '"' {
+					rest = rest[i+1:]
+					closed = true
+					break
+				}
+				value.WriteByte(c)
+			}
+			if !closed {
+				return "", fmt.Errorf("unterminated structured data value in %s", id)
+			}
+
+			fields[id+"."+name] = value.String()
+		}
+	}
+
+	return rest, nil
+}
+
+// parseRFC3164 parses the part of a BSD syslog message after the priority:
+// "Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG". The year is not part of the
+// message, so the one that puts the timestamp closest to now is used. Senders
+// that use an RFC 3339 timestamp or omit the hostname are also accepted.
+func parseRFC3164(rest string, now time.Time) (models.LogEntry, error) {
+	var entry models.LogEntry
+
+	if len(rest) >= 15 {
+		if ts, err := time.ParseInLocation(time.Stamp, rest[:15], time.Local); err == nil {
+			ts = ts.AddDate(now.Year(), 0, 0)
+			if ts.After(now.Add(24 * time.Hour)) {
+				ts = ts.AddDate(-1, 0, 0)
+			}
+			entry.Timestamp = ts
+			rest = strings.TrimPrefix(rest[15:], " ")
+		}
+	}
+	if entry.Timestamp.IsZero() {
+		if field, remaining, ok := nextField(rest); ok {
+			if ts, err := time.Parse(time.RFC3339Nano, field); err == nil {
+				entry.Timestamp = ts
+				rest = remaining
+			}
+		}
+	}
+
+	if field, remaining, ok := nextField(rest); ok && !isTag(field) {
+		entry.Host = field
+		rest = remaining
+	}
+
+	if colon := strings.Index(rest, ": "); colon > 0 && !strings.Contains(rest[:colon], " ") {
+		tag := rest[:colon]
+		rest = rest[colon+2:]
+
+		if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+			entry.Fields = map[string]interface{}{
+				"proc_id": tag[open+1 : len(tag)-1],
+			}
+			tag = tag[:open]
+		}
+		entry.Service = tag
+	}
+
+	entry.Message = rest
+	return entry, nil
+}
+
+// isTag reports whether a header field is a TAG rather than a hostname.
+func isTag(field string) bool {
+	return strings.HasSuffix(field, ":") || strings.HasSuffix(field, "]")
+}
+
+// nextField splits off the next space-separated field.
+func nextField(s string) (string, string, bool) {
+	if s == "" {
+		return "", "", false
+	}
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		return s[:i], s[i+1:], true
+	}
+	return s, "", true
+}
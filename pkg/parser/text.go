@@ -0,0 +1,190 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"awesomeProject6/internal/models"
+)
+
+// timestampLayouts are tried, in order, on the leading timestamp of plain text
+// lines and on logfmt time values.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+}
+
+// parseText treats the payload as a single free-form line. A leading RFC 3339
+// timestamp (optionally split into date and time) becomes the entry's
+// timestamp and the level is guessed from the first words.
+func parseText(data []byte) (models.LogEntry, error) {
+	message := strings.TrimRight(string(data), "\r\n")
+	if strings.TrimSpace(message) == "" {
+		return models.LogEntry{}, fmt.Errorf("empty message")
+	}
+
+	var entry models.LogEntry
+	if ts, rest, ok := leadingTimestamp(message); ok {
+		entry.Timestamp = ts
+		message = rest
+	}
+
+	entry.Message = message
+	entry.Level = detectLevel(message)
+	return entry, nil
+}
+
+func leadingTimestamp(line string) (time.Time, string, bool) {
+	fields := strings.SplitN(line, " ", 3)
+
+	if len(fields) >= 2 {
+		if ts, ok := parseTimestamp(fields[0] + " " + fields[1]); ok {
+			rest := ""
+			if len(fields) == 3 {
+				rest = fields[2]
+			}
+			return ts, rest, true
+		}
+	}
+	if ts, ok := parseTimestamp(fields[0]); ok {
+		return ts, strings.TrimPrefix(line, fields[0]+" "), true
+	}
+	return time.Time{}, line, false
+}
+
+func parseTimestamp(value string) (time.Time, bool) {
+	for _, layout := range timestampLayouts {
+		if ts, err := time.Parse(layout, value); err == nil {
+			return ts, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseLogfmt parses key=value pairs. Well-known keys map onto the entry and
+// every other key is stored in Fields. A line without a single key=value pair
+// is plain text rather than logfmt, so it is rejected.
+func parseLogfmt(data []byte) (models.LogEntry, error) {
+	pairs, assigned, err := splitLogfmt(strings.TrimSpace(string(data)))
+	if err != nil {
+		return models.LogEntry{}, err
+	}
+	if assigned == 0 {
+		return models.LogEntry{}, fmt.Errorf("no key=value pairs")
+	}
+
+	var entry models.LogEntry
+	for _, pair := range pairs {
+		key, value := pair[0], pair[1]
+		switch strings.ToLower(key) {
+		case "time", "ts", "timestamp", "@timestamp":
+			if ts, ok := parseTimestamp(value); ok {
+				entry.Timestamp = ts
+				continue
+			}
+		case "level", "lvl", "severity":
+			if level, ok := NormalizeLevel(value); ok {
+				entry.Level = level
+			} else {
+				entry.Level = strings.ToUpper(value)
+			}
+			continue
+		case "msg", "message":
+			entry.Message = value
+			continue
+		case "service", "app", "component":
+			if entry.Service == "" {
+				entry.Service = value
+				continue
+			}
+		case "host", "hostname":
+			entry.Host = value
+			continue
+		}
+
+		if entry.Fields == nil {
+			entry.Fields = make(map[string]interface{})
+		}
+		entry.Fields[key] = value
+	}
+
+	return entry, nil
+}
+
+// splitLogfmt splits a logfmt line into key/value pairs and counts the keys
+// that were assigned a value. Values may be double quoted with backslash
+// escapes; a bare key has an empty value.
+func splitLogfmt(line string) ([][2]string, int, error) {
+	var pairs [][2]string
+	assigned := 0
+
+	i := 0
+	for i < len(line) {
+		for i < len(line) && line[i] == ' ' {
+			i++
+		}
+		if i >= len(line) {
+			break
+		}
+
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' {
+			i++
+		}
+		key := line[start:i]
+		if key == "" {
+			return nil, 0, fmt.Errorf("empty key at offset %d", start)
+		}
+
+		if i >= len(line) || line[i] == ' ' {
+			pairs = append(pairs, [2]string{key, ""})
+			continue
+		}
+		i++ // skip '='
+		assigned++
+
+		if i < len(line) && line[i] == '"' {
+			var value strings.Builder
+			i++
+			closed := false
+			for i < len(line) {
+				c := line[i]
+				if c == '\\' && i+1 < len(line) {
+					switch line[i+1] {
+					case 'n':
+						value.WriteByte('\n')
+					case 't':
+						value.WriteByte('\t')
+					default:
+						value.WriteByte(line[i+1])
+					}
+					i += 2
+					continue
+				}
+				if c == '"' {
+					closed = true
+					i++
+					break
+				}
+				value.WriteByte(c)
+				i++
+			}
+			if !closed {
+				return nil, 0, fmt.Errorf("unterminated quoted value for %s", key)
+			}
+			pairs = append(pairs, [2]string{key, value.String()})
+			continue
+		}
+
+		start = i
+		for i < len(line) && line[i] != ' ' {
+			i++
+		}
+		pairs = append(pairs, [2]string{key, line[start:i]})
+	}
+
+	return pairs, assigned, nil
+}
@@ -0,0 +1,163 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"awesomeProject6/internal/models"
+)
+
+// grokPatterns is the subset of the standard grok pattern library supported
+// by the extract processor.
+var grokPatterns = map[string]string{
+	"WORD":              `\b\w+\b`,
+	"NOTSPACE":          `\S+`,
+	"SPACE":             `\s*`,
+	"DATA":              `.*?`,
+	"GREEDYDATA":        `.*`,
+	"INT":               `[+-]?\d+`,
+	"POSINT":            `\b[1-9]\d*\b`,
+	"NUMBER":            `[+-]?(?:\d+(?:\.\d*)?|\.\d+)`,
+	"BASE10NUM":         `[+-]?(?:\d+(?:\.\d*)?|\.\d+)`,
+	"IPV4":              `(?:\d{1,3}\.){3}\d{1,3}`,
+	"IPV6":              `[0-9A-Fa-f:]*:[0-9A-Fa-f:.]+`,
+	"IP":                `(?:(?:\d{1,3}\.){3}\d{1,3}|[0-9A-Fa-f:]*:[0-9A-Fa-f:.]+)`,
+	"HOSTNAME":          `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?\b`,
+	"UUID":              `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+	"PATH":              `(?:/[^\s]*)+`,
+	"URIPATH":           `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_\-]*)+`,
+	"EMAILADDRESS":      `[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`,
+	"QUOTEDSTRING":      `"(?:[^"\\]|\\.)*"`,
+	"LOGLEVEL":          `(?i:trace|debug|info|notice|warn(?:ing)?|err(?:or)?|crit(?:ical)?|fatal|panic)`,
+	"TIMESTAMP_ISO8601": `\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}(?::\d{2}(?:[.,]\d+)?)?(?:Z|[+-]\d{2}:?\d{2})?`,
+	"DURATION":          `[0-9.]+(?:ns|us|µs|ms|s|m|h)`,
+}
+
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::([\w.@-]+))?(?::(int|float))?\}`)
+
+// extract matches a regular expression against one value of the entry and
+// stores each named capture at the path given by its name.
+type extract struct {
+	source   string
+	re       *regexp.Regexp
+	targets  map[string]string
+	converts map[string]string
+}
+
+// newExtract compiles either a regexp with named groups or a grok expression.
+// Grok captures may be converted with a ":int" or ":float" suffix, e.g.
+// "%{NUMBER:duration_ms:float}".
+func newExtract(source, pattern, grok string) (Processor, error) {
+	if source == "" {
+		source = "message"
+	}
+	if err := validatePath(source); err != nil {
+		return nil, err
+	}
+
+	e := &extract{
+		source:   source,
+		targets:  make(map[string]string),
+		converts: make(map[string]string),
+	}
+
+	switch {
+	case pattern != "" && grok != "":
+		return nil, fmt.Errorf("extract takes either pattern or grok, not both")
+	case pattern != "":
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %v", err)
+		}
+		for _, name := range re.SubexpNames() {
+			if name != "" {
+				e.targets[name] = name
+			}
+		}
+		e.re = re
+	case grok != "":
+		expr, err := e.compileGrok(grok)
+		if err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid grok expression: %v", err)
+		}
+		e.re = re
+	default:
+		return nil, fmt.Errorf("extract requires pattern or grok")
+	}
+
+	if len(e.targets) == 0 {
+		return nil, fmt.Errorf("extract pattern has no named captures")
+	}
+	return e, nil
+}
+
+// compileGrok expands grok references into a regexp. Capture names may be
+// paths such as "tags.user", which are not valid group names, so groups are
+// numbered and mapped back to their target.
+func (e *extract) compileGrok(grok string) (string, error) {
+	var expandErr error
+	group := 0
+
+	expr := grokReference.ReplaceAllStringFunc(grok, func(ref string) string {
+		parts := grokReference.FindStringSubmatch(ref)
+		pattern, ok := grokPatterns[parts[1]]
+		if !ok {
+			expandErr = fmt.Errorf("unknown grok pattern %s", parts[1])
+			return ""
+		}
+		if parts[2] == "" {
+			return "(?:" + pattern + ")"
+		}
+
+		name := "g" + strconv.Itoa(group)
+		group++
+		e.targets[name] = parts[2]
+		if parts[3] != "" {
+			e.converts[name] = parts[3]
+		}
+		return "(?P<" + name + ">" + pattern + ")"
+	})
+
+	return expr, expandErr
+}
+
+func (e *extract) Process(entry *models.LogEntry) bool {
+	value, ok := Get(entry, e.source)
+	if !ok {
+		return true
+	}
+
+	match := e.re.FindStringSubmatch(value)
+	if match == nil {
+		return true
+	}
+
+	for i, name := range e.re.SubexpNames() {
+		target, ok := e.targets[name]
+		if !ok || match[i] == "" {
+			continue
+		}
+		Set(entry, target, convert(match[i], e.converts[name]))
+	}
+	return true
+}
+
+func convert(value, kind string) interface{} {
+	switch kind {
+	case "int":
+		if n, err := strconv.ParseInt(strings.TrimPrefix(value, "+"), 10, 64); err == nil {
+			return n
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return value
+}
@@ -0,0 +1,317 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"awesomeProject6/internal/models"
+)
+
+// Processor transforms a log entry in place. It returns false to drop the
+// entry, in which case later processors do not see it.
+type Processor interface {
+	Process(entry *models.LogEntry) bool
+}
+
+// Chain runs processors in order.
+type Chain []Processor
+
+func (c Chain) Process(entry *models.LogEntry) bool {
+	for _, p := range c {
+		if !p.Process(entry) {
+			return false
+		}
+	}
+	return true
+}
+
+// StageConfig is the YAML definition of one processor. Type selects the
+// processor and decides which of the other options apply:
+//
+//	drop, keep        drop entries that match / do not match When
+//	add_tags          set Tags
+//	add_fields        set Fields
+//	rename            move values from one path to another (Rename)
+//	remove            delete the paths in Remove
+//	extract           match Pattern (a regexp with named groups) or Grok
+//	                  against Source and store the captures
+//	normalize_level   map level spellings onto TRACE..FATAL, plus Mapping
+//	redact            replace Redact matches (email, credit_card, token or a
+//	                  regexp) in Targets with Replacement
+//
+// Every stage except drop and keep only applies to entries matching When.
+type StageConfig struct {
+	Type        string            `yaml:"type"`
+	When        []Condition       `yaml:"when"`
+	Tags        map[string]string `yaml:"tags"`
+	Fields      map[string]string `yaml:"fields"`
+	Rename      map[string]string `yaml:"rename"`
+	Remove      []string          `yaml:"remove"`
+	Source      string            `yaml:"source"`
+	Pattern     string            `yaml:"pattern"`
+	Grok        string            `yaml:"grok"`
+	Mapping     map[string]string `yaml:"mapping"`
+	Redact      []string          `yaml:"redact"`
+	Targets     []string          `yaml:"targets"`
+	Replacement string            `yaml:"replacement"`
+}
+
+// Condition tests one value of an entry, addressed by a path (see Get). All
+// of Equals, In, Matches and Exists that are set must hold; Not inverts the
+// result.
+type Condition struct {
+	Field   string   `yaml:"field"`
+	Equals  string   `yaml:"equals"`
+	In      []string `yaml:"in"`
+	Matches string   `yaml:"matches"`
+	Exists  *bool    `yaml:"exists"`
+	Not     bool     `yaml:"not"`
+}
+
+type condition struct {
+	Condition
+	re *regexp.Regexp
+}
+
+// Build compiles stage definitions into a chain.
+func Build(stages []StageConfig) (Chain, error) {
+	chain := make(Chain, 0, len(stages))
+	for i, stage := range stages {
+		p, err := NewStage(stage)
+		if err != nil {
+			return nil, fmt.Errorf("processor %d (%s): %v", i, stage.Type, err)
+		}
+		chain = append(chain, p)
+	}
+	return chain, nil
+}
+
+// NewStage compiles a single stage definition.
+func NewStage(stage StageConfig) (Processor, error) {
+	conditions, err := compileConditions(stage.When)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Processor
+	switch stage.Type {
+	case "drop", "keep":
+		if len(conditions) == 0 {
+			return nil, fmt.Errorf("%s requires at least one condition in when", stage.Type)
+		}
+		return &filter{conditions: conditions, keep: stage.Type == "keep"}, nil
+	case "add_tags":
+		p, err = newAddTags(stage.Tags)
+	case "add_fields":
+		p, err = newAddFields(stage.Fields)
+	case "rename":
+		p, err = newRename(stage.Rename)
+	case "remove":
+		p, err = newRemove(stage.Remove)
+	case "extract":
+		p, err = newExtract(stage.Source, stage.Pattern, stage.Grok)
+	case "normalize_level":
+		p, err = newNormalizeLevel(stage.Mapping)
+	case "redact":
+		p, err = newRedact(stage.Redact, stage.Targets, stage.Replacement)
+	default:
+		return nil, fmt.Errorf("unknown processor type %q", stage.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(conditions) > 0 {
+		p = &conditional{conditions: conditions, processor: p}
+	}
+	return p, nil
+}
+
+func compileConditions(conds []Condition) ([]condition, error) {
+	compiled := make([]condition, 0, len(conds))
+	for _, c := range conds {
+		if err := validatePath(c.Field); err != nil {
+			return nil, err
+		}
+		cc := condition{Condition: c}
+		if c.Matches != "" {
+			re, err := regexp.Compile(c.Matches)
+			if err != nil {
+				return nil, fmt.Errorf("invalid matches pattern for %s: %v", c.Field, err)
+			}
+			cc.re = re
+		}
+		compiled = append(compiled, cc)
+	}
+	return compiled, nil
+}
+
+func (c condition) matches(entry *models.LogEntry) bool {
+	value, exists := Get(entry, c.Field)
+
+	result := true
+	if c.Exists != nil && exists != *c.Exists {
+		result = false
+	}
+	if c.Equals != "" && value != c.Equals {
+		result = false
+	}
+	if len(c.In) > 0 && !contains(c.In, value) {
+		result = false
+	}
+	if c.re != nil && !c.re.MatchString(value) {
+		result = false
+	}
+
+	return result != c.Not
+}
+
+func matchesAll(conditions []condition, entry *models.LogEntry) bool {
+	for _, c := range conditions {
+		if !c.matches(entry) {
+			return false
+		}
+	}
+	return true
+}
+
+// filter drops entries matching its conditions, or with keep set, entries
+// that do not match them.
+type filter struct {
+	conditions []condition
+	keep       bool
+}
+
+func (f *filter) Process(entry *models.LogEntry) bool {
+	return matchesAll(f.conditions, entry) == f.keep
+}
+
+// conditional applies a processor only to entries matching its conditions.
+type conditional struct {
+	conditions []condition
+	processor  Processor
+}
+
+func (c *conditional) Process(entry *models.LogEntry) bool {
+	if !matchesAll(c.conditions, entry) {
+		return true
+	}
+	return c.processor.Process(entry)
+}
+
+// Paths address the values of an entry: "level", "message", "service",
+// "host", "tags.<name>" and "fields.<name>".
+func validatePath(path string) error {
+	switch path {
+	case "level", "message", "service", "host":
+		return nil
+	}
+	if name := strings.TrimPrefix(path, "tags."); name != path && name != "" {
+		return nil
+	}
+	if name := strings.TrimPrefix(path, "fields."); name != path && name != "" {
+		return nil
+	}
+	return fmt.Errorf("invalid path %q", path)
+}
+
+// Get returns the value at path as a string and whether it is set.
+func Get(entry *models.LogEntry, path string) (string, bool) {
+	switch path {
+	case "level":
+		return entry.Level, entry.Level != ""
+	case "message":
+		return entry.Message, entry.Message != ""
+	case "service":
+		return entry.Service, entry.Service != ""
+	case "host":
+		return entry.Host, entry.Host != ""
+	}
+
+	if name := strings.TrimPrefix(path, "tags."); name != path {
+		value, ok := entry.Tags[name]
+		return value, ok
+	}
+	if name := strings.TrimPrefix(path, "fields."); name != path {
+		value, ok := entry.Fields[name]
+		if !ok || value == nil {
+			return "", ok
+		}
+		if s, isString := value.(string); isString {
+			return s, true
+		}
+		return fmt.Sprint(value), true
+	}
+	return "", false
+}
+
+// Set stores value at path. Paths that are not one of the entry's own
+// attributes or tags are stored in Fields.
+func Set(entry *models.LogEntry, path string, value interface{}) {
+	switch path {
+	case "level":
+		entry.Level = fmt.Sprint(value)
+		return
+	case "message":
+		entry.Message = fmt.Sprint(value)
+		return
+	case "service":
+		entry.Service = fmt.Sprint(value)
+		return
+	case "host":
+		entry.Host = fmt.Sprint(value)
+		return
+	}
+
+	if name := strings.TrimPrefix(path, "tags."); name != path {
+		if entry.Tags == nil {
+			entry.Tags = make(map[string]string)
+		}
+		entry.Tags[name] = fmt.Sprint(value)
+		return
+	}
+
+	if entry.Fields == nil {
+		entry.Fields = make(map[string]interface{})
+	}
+	entry.Fields[strings.TrimPrefix(path, "fields.")] = value
+}
+
+// Delete removes the value at path.
+func Delete(entry *models.LogEntry, path string) {
+	switch path {
+	case "level":
+		entry.Level = ""
+	case "message":
+		entry.Message = ""
+	case "service":
+		entry.Service = ""
+	case "host":
+		entry.Host = ""
+	default:
+		if name := strings.TrimPrefix(path, "tags."); name != path {
+			delete(entry.Tags, name)
+		} else {
+			delete(entry.Fields, strings.TrimPrefix(path, "fields."))
+		}
+	}
+}
+
+// lookup returns the raw value at path, keeping the type of field values.
+func lookup(entry *models.LogEntry, path string) (interface{}, bool) {
+	if name := strings.TrimPrefix(path, "fields."); name != path {
+		value, ok := entry.Fields[name]
+		return value, ok
+	}
+	return Get(entry, path)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"awesomeProject6/internal/models"
+)
+
+const defaultReplacement = "[REDACTED]"
+
+// redactor finds sensitive values in a string and replaces them.
+type redactor func(value, replacement string) string
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	cardPattern  = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+
+	// Bearer tokens, JWTs and values of secret-looking keys such as
+	// "api_key=..." or "password: ...". The key itself is kept.
+	bearerPattern = regexp.MustCompile(`(?i)\b(bearer\s+)[A-Za-z0-9\-._~+/]+=*`)
+	jwtPattern    = regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)
+	secretPattern = regexp.MustCompile(`(?i)\b((?:api[_-]?key|access[_-]?token|auth[_-]?token|token|secret|password|passwd|pwd)["']?\s*[=:]\s*["']?)[^\s"',&;]+`)
+)
+
+var builtinRedactors = map[string]redactor{
+	"email": func(value, replacement string) string {
+		return emailPattern.ReplaceAllLiteralString(value, replacement)
+	},
+	"credit_card": func(value, replacement string) string {
+		return cardPattern.ReplaceAllStringFunc(value, func(match string) string {
+			if luhnValid(match) {
+				return replacement
+			}
+			return match
+		})
+	},
+	"token": func(value, replacement string) string {
+		value = jwtPattern.ReplaceAllLiteralString(value, replacement)
+		value = bearerPattern.ReplaceAllString(value, "${1}"+escapeTemplate(replacement))
+		return secretPattern.ReplaceAllString(value, "${1}"+escapeTemplate(replacement))
+	},
+}
+
+// redact replaces sensitive values in its targets. Without targets it
+// covers the message, every tag and every string field, including strings
+// nested in objects and arrays.
+type redact struct {
+	redactors   []redactor
+	targets     []string
+	replacement string
+}
+
+func newRedact(kinds, targets []string, replacement string) (Processor, error) {
+	if len(kinds) == 0 {
+		return nil, fmt.Errorf("redact requires redact")
+	}
+	if replacement == "" {
+		replacement = defaultReplacement
+	}
+	for _, target := range targets {
+		if err := validatePath(target); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &redact{targets: targets, replacement: replacement}
+	for _, kind := range kinds {
+		if builtin, ok := builtinRedactors[kind]; ok {
+			r.redactors = append(r.redactors, builtin)
+			continue
+		}
+
+		re, err := regexp.Compile(kind)
+		if err != nil {
+			return nil, fmt.Errorf("redact %q is neither email, credit_card, token nor a valid pattern: %v", kind, err)
+		}
+		r.redactors = append(r.redactors, func(value, replacement string) string {
+			return re.ReplaceAllLiteralString(value, replacement)
+		})
+	}
+	return r, nil
+}
+
+func (r *redact) Process(entry *models.LogEntry) bool {
+	if len(r.targets) > 0 {
+		for _, target := range r.targets {
+			if value, ok := lookup(entry, target); ok {
+				Set(entry, target, r.redactValue(value))
+			}
+		}
+		return true
+	}
+
+	entry.Message = r.apply(entry.Message)
+	for k, v := range entry.Tags {
+		entry.Tags[k] = r.apply(v)
+	}
+	for k, v := range entry.Fields {
+		entry.Fields[k] = r.redactValue(v)
+	}
+	return true
+}
+
+// redactValue redacts a string, or every string inside a decoded JSON object
+// or array, leaving other values untouched.
+func (r *redact) redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return r.apply(v)
+	case map[string]interface{}:
+		for k, nested := range v {
+			v[k] = r.redactValue(nested)
+		}
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = r.redactValue(nested)
+		}
+	}
+	return value
+}
+
+func (r *redact) apply(value string) string {
+	for _, redactor := range r.redactors {
+		value = redactor(value, r.replacement)
+	}
+	return value
+}
+
+// luhnValid reports whether the digits in number pass the Luhn checksum used
+// by payment card numbers, to avoid redacting other long numbers.
+func luhnValid(number string) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+func escapeTemplate(s string) string {
+	return strings.ReplaceAll(s, "$", "$$")
+}
@@ -0,0 +1,145 @@
+package processor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"awesomeProject6/internal/models"
+	"awesomeProject6/pkg/parser"
+)
+
+type addTags struct {
+	tags map[string]string
+}
+
+func newAddTags(tags map[string]string) (Processor, error) {
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("add_tags requires tags")
+	}
+	return &addTags{tags: tags}, nil
+}
+
+func (a *addTags) Process(entry *models.LogEntry) bool {
+	if entry.Tags == nil {
+		entry.Tags = make(map[string]string, len(a.tags))
+	}
+	for k, v := range a.tags {
+		entry.Tags[k] = v
+	}
+	return true
+}
+
+type addFields struct {
+	fields map[string]string
+}
+
+func newAddFields(fields map[string]string) (Processor, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("add_fields requires fields")
+	}
+	return &addFields{fields: fields}, nil
+}
+
+func (a *addFields) Process(entry *models.LogEntry) bool {
+	if entry.Fields == nil {
+		entry.Fields = make(map[string]interface{}, len(a.fields))
+	}
+	for k, v := range a.fields {
+		entry.Fields[k] = v
+	}
+	return true
+}
+
+// rename moves values between paths, applied in order of their source path
+// so the result does not depend on map iteration.
+type rename struct {
+	from []string
+	to   []string
+}
+
+func newRename(paths map[string]string) (Processor, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("rename requires rename")
+	}
+
+	r := &rename{}
+	for from := range paths {
+		r.from = append(r.from, from)
+	}
+	sort.Strings(r.from)
+
+	for _, from := range r.from {
+		to := paths[from]
+		if err := validatePath(from); err != nil {
+			return nil, err
+		}
+		if err := validatePath(to); err != nil {
+			return nil, err
+		}
+		r.to = append(r.to, to)
+	}
+	return r, nil
+}
+
+func (r *rename) Process(entry *models.LogEntry) bool {
+	for i, from := range r.from {
+		value, ok := lookup(entry, from)
+		if !ok {
+			continue
+		}
+		Delete(entry, from)
+		Set(entry, r.to[i], value)
+	}
+	return true
+}
+
+type remove struct {
+	paths []string
+}
+
+func newRemove(paths []string) (Processor, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("remove requires remove")
+	}
+	for _, path := range paths {
+		if err := validatePath(path); err != nil {
+			return nil, err
+		}
+	}
+	return &remove{paths: paths}, nil
+}
+
+func (r *remove) Process(entry *models.LogEntry) bool {
+	for _, path := range r.paths {
+		Delete(entry, path)
+	}
+	return true
+}
+
+// normalizeLevel rewrites levels to the names used by stored entries. Custom
+// mappings are matched case-insensitively and take precedence over the
+// built-in spellings; unknown levels are upper-cased.
+type normalizeLevel struct {
+	mapping map[string]string
+}
+
+func newNormalizeLevel(mapping map[string]string) (Processor, error) {
+	n := &normalizeLevel{mapping: make(map[string]string, len(mapping))}
+	for from, to := range mapping {
+		n.mapping[strings.ToUpper(from)] = strings.ToUpper(to)
+	}
+	return n, nil
+}
+
+func (n *normalizeLevel) Process(entry *models.LogEntry) bool {
+	level := strings.ToUpper(strings.TrimSpace(entry.Level))
+	if mapped, ok := n.mapping[level]; ok {
+		entry.Level = mapped
+	} else if mapped, ok := parser.NormalizeLevel(level); ok {
+		entry.Level = mapped
+	} else {
+		entry.Level = level
+	}
+	return true
+}
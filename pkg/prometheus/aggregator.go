@@ -1,6 +1,7 @@
 package prometheus
 
 import (
+	"fmt"
 	"math"
 	"sort"
 	"time"
@@ -10,135 +11,308 @@ type Aggregator struct {
 	collector *MetricCollector
 }
 
+// Sample is the aggregated value of a single series, identified by its labels.
+type Sample struct {
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+// AnomalySample is a Sample together with the baseline it was compared to.
+// Deviation is expressed in standard deviations from the baseline mean.
+type AnomalySample struct {
+	Sample
+	Mean      float64 `json:"mean"`
+	StdDev    float64 `json:"stddev"`
+	Deviation float64 `json:"deviation"`
+}
+
+const (
+	BaselineRolling  = "rolling"
+	BaselineLastWeek = "last_week"
+)
+
+const week = 7 * 24 * time.Hour
+
+// DefaultMinStdDevRatio is the fraction of the baseline mean used as the
+// smallest standard deviation when none is configured.
+const DefaultMinStdDevRatio = 0.1
+
+// minStdDev keeps a perfectly flat baseline from dividing by zero.
+const minStdDev = 1e-6
+
+// reduceFunc aggregates the values of a series that fell inside a window of
+// the given length.
+type reduceFunc func(values []float64, window time.Duration) float64
+
 func NewAggregator(collector *MetricCollector) *Aggregator {
 	return &Aggregator{
 		collector: collector,
 	}
 }
 
-func (a *Aggregator) Sum(name string, labels map[string]string, duration time.Duration) float64 {
-	series, exists := a.collector.GetMetrics(name, labels)
-	if !exists {
-		return 0
-	}
-
-	series.mutex.RLock()
-	defer series.mutex.RUnlock()
+// Sum, Average, Max, Min, Percentile and Rate aggregate the single series
+// with exactly the given labels. The boolean is false when that series does
+// not exist or has no data points in the window, so callers can tell missing
+// data from a zero value.
+func (a *Aggregator) Sum(name string, labels map[string]string, duration time.Duration) (float64, bool) {
+	return a.scalar(sumValues, name, labels, duration)
+}
 
-	cutoff := time.Now().Add(-duration)
-	sum := 0.0
+func (a *Aggregator) Average(name string, labels map[string]string, duration time.Duration) (float64, bool) {
+	return a.scalar(averageValues, name, labels, duration)
+}
 
-	for _, dp := range series.Values {
-		if dp.Timestamp.After(cutoff) {
-			sum += dp.Value
-		}
-	}
+func (a *Aggregator) Max(name string, labels map[string]string, duration time.Duration) (float64, bool) {
+	return a.scalar(maxValues, name, labels, duration)
+}
 
-	return sum
+func (a *Aggregator) Min(name string, labels map[string]string, duration time.Duration) (float64, bool) {
+	return a.scalar(minValues, name, labels, duration)
 }
 
-func (a *Aggregator) Average(name string, labels map[string]string, duration time.Duration) float64 {
-	series, exists := a.collector.GetMetrics(name, labels)
-	if !exists {
-		return 0
-	}
+func (a *Aggregator) Percentile(name string, labels map[string]string, duration time.Duration, percentile float64) (float64, bool) {
+	return a.scalar(percentileValues(percentile), name, labels, duration)
+}
 
-	series.mutex.RLock()
-	defer series.mutex.RUnlock()
+func (a *Aggregator) Rate(name string, labels map[string]string, duration time.Duration) (float64, bool) {
+	return a.scalar(rateValues, name, labels, duration)
+}
 
-	cutoff := time.Now().Add(-duration)
-	sum := 0.0
-	count := 0
+// Vector applies function to every series of name matching the given labels
+// and returns one sample per series. Series without data points in the window
+// are left out, so an empty result means no data rather than zero.
+func (a *Aggregator) Vector(function, name string, matchers map[string]string, duration time.Duration) ([]Sample, error) {
+	fn, err := lookupReduceFunc(function)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, dp := range series.Values {
-		if dp.Timestamp.After(cutoff) {
-			sum += dp.Value
-			count++
+	now := time.Now()
+	matched := a.collector.FindMetrics(name, matchers)
+	samples := make([]Sample, 0, len(matched))
+	for _, series := range matched {
+		values := series.valuesBetween(now.Add(-duration), now)
+		if len(values) == 0 {
+			continue
 		}
-	}
 
-	if count == 0 {
-		return 0
+		samples = append(samples, Sample{
+			Labels: series.Labels,
+			Value:  fn(values, duration),
+		})
 	}
 
-	return sum / float64(count)
+	return samples, nil
 }
 
-func (a *Aggregator) Max(name string, labels map[string]string, duration time.Duration) float64 {
-	series, exists := a.collector.GetMetrics(name, labels)
-	if !exists {
-		return 0
+// Staleness returns, for every series of name matching the given labels, the
+// number of seconds since its most recent data point.
+func (a *Aggregator) Staleness(name string, matchers map[string]string) []Sample {
+	now := time.Now()
+	matched := a.collector.FindMetrics(name, matchers)
+	samples := make([]Sample, 0, len(matched))
+	for _, series := range matched {
+		series.mutex.RLock()
+		lastSeen := series.LastSeen
+		series.mutex.RUnlock()
+
+		samples = append(samples, Sample{
+			Labels: series.Labels,
+			Value:  now.Sub(lastSeen).Seconds(),
+		})
 	}
 
-	series.mutex.RLock()
-	defer series.mutex.RUnlock()
+	return samples
+}
 
-	cutoff := time.Now().Add(-duration)
-	max := math.Inf(-1)
+// AnomalyVector compares the current value of every matching series with a
+// baseline built from its own history. The baseline window is split into
+// buckets of duration, function is applied to each bucket, and the mean and
+// standard deviation of those bucket values form the baseline. The standard
+// deviation is raised to at least minRatio of the mean, so a flat baseline
+// still flags a spike. Series without current data or at least two populated
+// buckets are skipped.
+func (a *Aggregator) AnomalyVector(function, name string, matchers map[string]string, duration, history time.Duration, method string, minRatio float64) ([]AnomalySample, error) {
+	fn, err := lookupReduceFunc(function)
+	if err != nil {
+		return nil, err
+	}
+	if duration <= 0 || history < 2*duration {
+		return nil, fmt.Errorf("history %s must cover at least two %s buckets", history, duration)
+	}
 
-	for _, dp := range series.Values {
-		if dp.Timestamp.After(cutoff) {
-			if dp.Value > max {
-				max = dp.Value
+	now := time.Now()
+	var baselineEnd time.Time
+	switch method {
+	case "", BaselineRolling:
+		baselineEnd = now.Add(-duration)
+	case BaselineLastWeek:
+		baselineEnd = now.Add(-week).Add(history / 2)
+	default:
+		return nil, fmt.Errorf("unknown baseline method: %s", method)
+	}
+	if minRatio <= 0 {
+		minRatio = DefaultMinStdDevRatio
+	}
+
+	matched := a.collector.FindMetrics(name, matchers)
+	samples := make([]AnomalySample, 0, len(matched))
+	for _, series := range matched {
+		var buckets []float64
+		for end := baselineEnd; end.After(baselineEnd.Add(-history)); end = end.Add(-duration) {
+			values := series.valuesBetween(end.Add(-duration), end)
+			if len(values) > 0 {
+				buckets = append(buckets, fn(values, duration))
 			}
 		}
-	}
+		if len(buckets) < 2 {
+			continue
+		}
 
-	if math.IsInf(max, -1) {
-		return 0
+		mean, stddev := meanStdDev(buckets)
+		stddev = math.Max(stddev, math.Max(minRatio*math.Abs(mean), minStdDev))
+
+		current := series.valuesBetween(now.Add(-duration), now)
+		if len(current) == 0 {
+			continue
+		}
+
+		value := fn(current, duration)
+		samples = append(samples, AnomalySample{
+			Sample: Sample{
+				Labels: series.Labels,
+				Value:  value,
+			},
+			Mean:      mean,
+			StdDev:    stddev,
+			Deviation: (value - mean) / stddev,
+		})
 	}
 
-	return max
+	return samples, nil
 }
 
-func (a *Aggregator) Percentile(name string, labels map[string]string, duration time.Duration, percentile float64) float64 {
+func (a *Aggregator) scalar(fn reduceFunc, name string, labels map[string]string, duration time.Duration) (float64, bool) {
 	series, exists := a.collector.GetMetrics(name, labels)
 	if !exists {
-		return 0
+		return 0, false
 	}
 
-	series.mutex.RLock()
-	defer series.mutex.RUnlock()
+	now := time.Now()
+	values := series.valuesBetween(now.Add(-duration), now)
+	if len(values) == 0 {
+		return 0, false
+	}
+	return fn(values, duration), true
+}
 
-	cutoff := time.Now().Add(-duration)
-	var values []float64
+func lookupReduceFunc(function string) (reduceFunc, error) {
+	switch function {
+	case "sum":
+		return sumValues, nil
+	case "avg":
+		return averageValues, nil
+	case "max":
+		return maxValues, nil
+	case "min":
+		return minValues, nil
+	case "rate":
+		return rateValues, nil
+	case "p95":
+		return percentileValues(95), nil
+	case "p99":
+		return percentileValues(99), nil
+	default:
+		return nil, fmt.Errorf("unknown function: %s", function)
+	}
+}
+
+// valuesBetween returns the values recorded in the half-open window (from, to].
+func (s *MetricSeries) valuesBetween(from, to time.Time) []float64 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 
-	for _, dp := range series.Values {
-		if dp.Timestamp.After(cutoff) {
+	var values []float64
+	for _, dp := range s.Values {
+		if dp.Timestamp.After(from) && !dp.Timestamp.After(to) {
 			values = append(values, dp.Value)
 		}
 	}
+	return values
+}
 
+func sumValues(values []float64, _ time.Duration) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum
+}
+
+func averageValues(values []float64, _ time.Duration) float64 {
 	if len(values) == 0 {
 		return 0
 	}
+	return sumValues(values, 0) / float64(len(values))
+}
 
-	sort.Float64s(values)
-	index := int(float64(len(values)) * percentile / 100.0)
-	if index >= len(values) {
-		index = len(values) - 1
+func maxValues(values []float64, _ time.Duration) float64 {
+	max := math.Inf(-1)
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
 	}
 
-	return values[index]
+	if math.IsInf(max, -1) {
+		return 0
+	}
+	return max
 }
 
-func (a *Aggregator) Rate(name string, labels map[string]string, duration time.Duration) float64 {
-	series, exists := a.collector.GetMetrics(name, labels)
-	if !exists {
-		return 0
+func minValues(values []float64, _ time.Duration) float64 {
+	min := math.Inf(1)
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
 	}
 
-	series.mutex.RLock()
-	defer series.mutex.RUnlock()
+	if math.IsInf(min, 1) {
+		return 0
+	}
+	return min
+}
 
-	cutoff := time.Now().Add(-duration)
-	count := 0
+func percentileValues(percentile float64) reduceFunc {
+	return func(values []float64, _ time.Duration) float64 {
+		if len(values) == 0 {
+			return 0
+		}
 
-	for _, dp := range series.Values {
-		if dp.Timestamp.After(cutoff) {
-			count++
+		sorted := append([]float64(nil), values...)
+		sort.Float64s(sorted)
+		index := int(float64(len(sorted)) * percentile / 100.0)
+		if index >= len(sorted) {
+			index = len(sorted) - 1
 		}
+
+		return sorted[index]
 	}
+}
 
-	return float64(count) / duration.Seconds()
-}
\ No newline at end of file
+func rateValues(values []float64, window time.Duration) float64 {
+	return float64(len(values)) / window.Seconds()
+}
+
+func meanStdDev(values []float64) (float64, float64) {
+	mean := averageValues(values, 0)
+
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(len(values))
+
+	return mean, math.Sqrt(variance)
+}
@@ -1,14 +1,16 @@
 package prometheus
 
 import (
+	"sort"
 	"sync"
 	"time"
 	"awesomeProject6/internal/models"
 )
 
 type MetricCollector struct {
-	metrics map[string]*MetricSeries
-	mutex   sync.RWMutex
+	metrics   map[string]*MetricSeries
+	retention time.Duration
+	mutex     sync.RWMutex
 }
 
 type MetricSeries struct {
@@ -16,6 +18,7 @@ type MetricSeries struct {
 	Type     string
 	Values   []DataPoint
 	Labels   map[string]string
+	LastSeen time.Time
 	mutex    sync.RWMutex
 }
 
@@ -26,10 +29,20 @@ type DataPoint struct {
 
 func NewMetricCollector() *MetricCollector {
 	return &MetricCollector{
-		metrics: make(map[string]*MetricSeries),
+		metrics:   make(map[string]*MetricSeries),
+		retention: 24 * time.Hour,
 	}
 }
 
+// SetRetention changes how long data points are kept. Baselines that look
+// back a week need a retention of at least eight days.
+func (mc *MetricCollector) SetRetention(retention time.Duration) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	mc.retention = retention
+}
+
 func (mc *MetricCollector) RecordMetric(metric models.Metric) {
 	mc.mutex.Lock()
 	defer mc.mutex.Unlock()
@@ -52,6 +65,9 @@ func (mc *MetricCollector) RecordMetric(metric models.Metric) {
 		Value:     metric.Value,
 		Timestamp: metric.Timestamp,
 	})
+	if metric.Timestamp.After(series.LastSeen) {
+		series.LastSeen = metric.Timestamp
+	}
 	
 	mc.pruneOldValues(series)
 	series.mutex.Unlock()
@@ -66,6 +82,21 @@ func (mc *MetricCollector) GetMetrics(name string, labels map[string]string) (*M
 	return series, exists
 }
 
+// FindMetrics returns every series with the given name whose labels contain
+// all of the given matchers.
+func (mc *MetricCollector) FindMetrics(name string, matchers map[string]string) []*MetricSeries {
+	mc.mutex.RLock()
+	defer mc.mutex.RUnlock()
+
+	var result []*MetricSeries
+	for _, series := range mc.metrics {
+		if series.Name == name && matchLabels(series.Labels, matchers) {
+			result = append(result, series)
+		}
+	}
+	return result
+}
+
 func (mc *MetricCollector) GetAllMetrics() map[string]*MetricSeries {
 	mc.mutex.RLock()
 	defer mc.mutex.RUnlock()
@@ -78,15 +109,30 @@ func (mc *MetricCollector) GetAllMetrics() map[string]*MetricSeries {
 }
 
 func (mc *MetricCollector) generateKey(name string, labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
 	key := name
-	for k, v := range labels {
-		key += "__" + k + "=" + v
+	for _, k := range names {
+		key += "__" + k + "=" + labels[k]
 	}
 	return key
 }
 
+func matchLabels(labels, matchers map[string]string) bool {
+	for k, v := range matchers {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
 func (mc *MetricCollector) pruneOldValues(series *MetricSeries) {
-	cutoff := time.Now().Add(-24 * time.Hour)
+	cutoff := time.Now().Add(-mc.retention)
 	var pruned []DataPoint
 	
 	for _, dp := range series.Values {
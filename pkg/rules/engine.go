@@ -2,39 +2,74 @@ package rules
 
 import (
 	"context"
+	"fmt"
+	"math"
 	"strings"
+	"sync"
 	"time"
 
 	"awesomeProject6/internal/models"
+	"awesomeProject6/pkg/elasticsearch"
 	"awesomeProject6/pkg/prometheus"
 	"github.com/sirupsen/logrus"
 )
 
 type Engine struct {
-	rules      []models.AlertRule
-	aggregator *prometheus.Aggregator
-	alertChan  chan models.Alert
-	logger     *logrus.Logger
+	rules          []models.AlertRule
+	aggregator     *prometheus.Aggregator
+	esClient       *elasticsearch.Client
+	alertChan      chan models.Alert
+	transitionChan chan models.AlertTransition
+	states         map[string]map[string]*seriesState
+	lastEvaluation map[string]time.Time
+	lastError      map[string]string
+	mutex          sync.RWMutex
+	logger         *logrus.Logger
 }
 
-func NewEngine(aggregator *prometheus.Aggregator, alertChan chan models.Alert) *Engine {
+// NewEngine creates a rule engine. esClient may be nil when no log rules are
+// loaded.
+func NewEngine(aggregator *prometheus.Aggregator, esClient *elasticsearch.Client, alertChan chan models.Alert) *Engine {
 	return &Engine{
-		rules:      make([]models.AlertRule, 0),
-		aggregator: aggregator,
-		alertChan:  alertChan,
-		logger:     logrus.New(),
+		rules:          make([]models.AlertRule, 0),
+		aggregator:     aggregator,
+		esClient:       esClient,
+		alertChan:      alertChan,
+		transitionChan: make(chan models.AlertTransition, 100),
+		states:         make(map[string]map[string]*seriesState),
+		lastEvaluation: make(map[string]time.Time),
+		lastError:      make(map[string]string),
+		logger:         logrus.New(),
 	}
 }
 
 func (e *Engine) AddRule(rule models.AlertRule) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
 	e.rules = append(e.rules, rule)
 }
 
 func (e *Engine) LoadRules(rules []models.AlertRule) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
 	e.rules = rules
+	e.states = make(map[string]map[string]*seriesState)
+	e.lastEvaluation = make(map[string]time.Time)
+	e.lastError = make(map[string]string)
+}
+
+// Transitions returns the channel on which every alert status change is
+// published. It is closed when Start returns.
+func (e *Engine) Transitions() <-chan models.AlertTransition {
+	return e.transitionChan
 }
 
+// Start evaluates the rules every interval until ctx is done.
 func (e *Engine) Start(ctx context.Context, interval time.Duration) {
+	defer close(e.transitionChan)
+
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
@@ -43,16 +78,30 @@ func (e *Engine) Start(ctx context.Context, interval time.Duration) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			e.evaluateRules()
+			e.evaluateRules(ctx)
 		}
 	}
 }
 
-func (e *Engine) evaluateRules() {
-	for _, rule := range e.rules {
-		if alert := e.evaluateRule(rule); alert != nil {
+func (e *Engine) evaluateRules(ctx context.Context) {
+	e.mutex.RLock()
+	rules := e.rules
+	e.mutex.RUnlock()
+
+	for _, rule := range rules {
+		alerts, transitions := e.evaluateRule(ctx, rule)
+
+		for _, transition := range transitions {
 			select {
-			case e.alertChan <- *alert:
+			case e.transitionChan <- transition:
+			default:
+				e.logger.Warn("Transition channel is full, dropping transition")
+			}
+		}
+
+		for _, alert := range alerts {
+			select {
+			case e.alertChan <- alert:
 			default:
 				e.logger.Warn("Alert channel is full, dropping alert")
 			}
@@ -60,60 +109,197 @@ func (e *Engine) evaluateRules() {
 	}
 }
 
-func (e *Engine) evaluateRule(rule models.AlertRule) *models.Alert {
-	duration, err := time.ParseDuration(rule.Duration)
+// seriesResult is the outcome of evaluating a rule against a single series.
+type seriesResult struct {
+	labels    map[string]string
+	value     float64
+	triggered bool
+	baseline  *models.Baseline
+}
+
+// evaluateRule runs the rule's query and returns one alert for every series
+// that is firing or has no data, along with any status transitions.
+func (e *Engine) evaluateRule(ctx context.Context, rule models.AlertRule) ([]models.Alert, []models.AlertTransition) {
+	results, err := e.queryRule(ctx, rule)
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := time.Now()
+	e.lastEvaluation[rule.Name] = now
+
+	var alerts []models.Alert
+	var transitions []models.AlertTransition
+	if err == nil {
+		alerts, transitions, err = e.applyResults(rule, results, now)
+	}
 	if err != nil {
-		e.logger.Errorf("Invalid duration in rule %s: %v", rule.Name, err)
-		return nil
+		e.logger.Errorf("Failed to evaluate rule %s: %v", rule.Name, err)
+		e.lastError[rule.Name] = err.Error()
+		return nil, nil
 	}
 
-	value := e.executeQuery(rule.Query, duration)
-	threshold := rule.Threshold
+	delete(e.lastError, rule.Name)
+	return alerts, transitions
+}
 
-	triggered := false
-	switch rule.Operator {
-	case ">":
-		triggered = value > threshold
-	case ">=":
-		triggered = value >= threshold
-	case "<":
-		triggered = value < threshold
-	case "<=":
-		triggered = value <= threshold
-	case "==":
-		triggered = value == threshold
-	case "!=":
-		triggered = value != threshold
+func (e *Engine) queryRule(ctx context.Context, rule models.AlertRule) ([]seriesResult, error) {
+	duration, err := time.ParseDuration(rule.Duration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid duration: %v", err)
+	}
+
+	switch rule.Type {
+	case "", models.RuleTypeMetric:
+		return e.evaluateThreshold(rule, func() ([]prometheus.Sample, error) {
+			return e.executeQuery(rule.Query, duration)
+		})
+	case models.RuleTypeLog:
+		return e.evaluateThreshold(rule, func() ([]prometheus.Sample, error) {
+			return e.executeLogQuery(ctx, rule.LogQuery, duration)
+		})
+	case models.RuleTypeAnomaly:
+		return e.evaluateAnomaly(rule, duration)
+	case models.RuleTypeStale:
+		return e.evaluateStale(rule, duration)
 	default:
-		e.logger.Errorf("Unknown operator in rule %s: %s", rule.Name, rule.Operator)
-		return nil
+		return nil, fmt.Errorf("unknown rule type: %s", rule.Type)
 	}
+}
 
-	if triggered {
-		return &models.Alert{
-			Rule:      rule,
-			Value:     value,
-			Timestamp: time.Now(),
-			Status:    "firing",
-			Labels:    rule.Labels,
+func (e *Engine) evaluateThreshold(rule models.AlertRule, query func() ([]prometheus.Sample, error)) ([]seriesResult, error) {
+	samples, err := query()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]seriesResult, 0, len(samples))
+	for _, sample := range samples {
+		triggered, err := compare(sample.Value, rule.Operator, rule.Threshold)
+		if err != nil {
+			return nil, err
 		}
+
+		results = append(results, seriesResult{
+			labels:    sample.Labels,
+			value:     sample.Value,
+			triggered: triggered,
+		})
 	}
 
-	return nil
+	return results, nil
 }
 
-func (e *Engine) executeQuery(query string, duration time.Duration) float64 {
+// evaluateAnomaly compares each series with its historical baseline and
+// triggers when the deviation exceeds the configured number of sigmas.
+func (e *Engine) evaluateAnomaly(rule models.AlertRule, duration time.Duration) ([]seriesResult, error) {
+	if rule.Anomaly == nil {
+		return nil, fmt.Errorf("anomaly rule has no anomaly config")
+	}
+	if rule.Anomaly.Sigma <= 0 {
+		return nil, fmt.Errorf("anomaly sigma must be positive")
+	}
+
+	history, err := time.ParseDuration(rule.Anomaly.History)
+	if err != nil {
+		return nil, fmt.Errorf("invalid anomaly history: %v", err)
+	}
+
+	function, metricName, labels, err := parseQuery(rule.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	samples, err := e.aggregator.AnomalyVector(function, metricName, labels, duration, history, rule.Anomaly.Method, rule.Anomaly.MinStdDev)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]seriesResult, 0, len(samples))
+	for _, sample := range samples {
+		var triggered bool
+		switch rule.Anomaly.Direction {
+		case "", "both":
+			triggered = math.Abs(sample.Deviation) > rule.Anomaly.Sigma
+		case "above":
+			triggered = sample.Deviation > rule.Anomaly.Sigma
+		case "below":
+			triggered = -sample.Deviation > rule.Anomaly.Sigma
+		default:
+			return nil, fmt.Errorf("unknown anomaly direction: %s", rule.Anomaly.Direction)
+		}
+
+		results = append(results, seriesResult{
+			labels:    sample.Labels,
+			value:     sample.Value,
+			triggered: triggered,
+			baseline: &models.Baseline{
+				Mean:      sample.Mean,
+				StdDev:    sample.StdDev,
+				Deviation: sample.Deviation,
+			},
+		})
+	}
+
+	return results, nil
+}
+
+// evaluateStale triggers for every matching series whose most recent data
+// point is older than duration. The value is the series age in seconds.
+func (e *Engine) evaluateStale(rule models.AlertRule, duration time.Duration) ([]seriesResult, error) {
+	metricName, labels, err := parseSelector(strings.Fields(rule.Query))
+	if err != nil {
+		return nil, err
+	}
+
+	samples := e.aggregator.Staleness(metricName, labels)
+	results := make([]seriesResult, 0, len(samples))
+	for _, sample := range samples {
+		results = append(results, seriesResult{
+			labels:    sample.Labels,
+			value:     sample.Value,
+			triggered: sample.Value > duration.Seconds(),
+		})
+	}
+
+	return results, nil
+}
+
+func (e *Engine) executeQuery(query string, duration time.Duration) ([]prometheus.Sample, error) {
+	function, metricName, labels, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.aggregator.Vector(function, metricName, labels, duration)
+}
+
+// parseQuery splits "<function> <metric> [label=value ...]" into its parts.
+func parseQuery(query string) (string, string, map[string]string, error) {
 	parts := strings.Fields(query)
 	if len(parts) < 2 {
-		return 0
+		return "", "", nil, fmt.Errorf("invalid query: %q", query)
+	}
+
+	metricName, labels, err := parseSelector(parts[1:])
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	return parts[0], metricName, labels, nil
+}
+
+// parseSelector parses "<metric> [label=value ...]" from already split fields.
+func parseSelector(parts []string) (string, map[string]string, error) {
+	if len(parts) < 1 {
+		return "", nil, fmt.Errorf("missing metric name")
 	}
 
-	function := parts[0]
-	metricName := parts[1]
+	metricName := parts[0]
 
 	labels := make(map[string]string)
-	if len(parts) > 2 {
-		for i := 2; i < len(parts); i++ {
+	if len(parts) > 1 {
+		for i := 1; i < len(parts); i++ {
 			if strings.Contains(parts[i], "=") {
 				kv := strings.Split(parts[i], "=")
 				if len(kv) == 2 {
@@ -123,20 +309,79 @@ func (e *Engine) executeQuery(query string, duration time.Duration) float64 {
 		}
 	}
 
-	switch function {
-	case "sum":
-		return e.aggregator.Sum(metricName, labels, duration)
-	case "avg":
-		return e.aggregator.Average(metricName, labels, duration)
-	case "max":
-		return e.aggregator.Max(metricName, labels, duration)
-	case "rate":
-		return e.aggregator.Rate(metricName, labels, duration)
-	case "p95":
-		return e.aggregator.Percentile(metricName, labels, duration, 95)
-	case "p99":
-		return e.aggregator.Percentile(metricName, labels, duration, 99)
+	return metricName, labels, nil
+}
+
+// executeLogQuery counts the logs matching query over the last duration. A
+// grouped query yields one sample per term, labelled with the group field.
+func (e *Engine) executeLogQuery(ctx context.Context, query *models.LogQuery, duration time.Duration) ([]prometheus.Sample, error) {
+	if query == nil {
+		return nil, fmt.Errorf("log rule has no log_query")
+	}
+	if e.esClient == nil {
+		return nil, fmt.Errorf("no elasticsearch client configured")
+	}
+
+	now := time.Now()
+	filter := elasticsearch.LogFilter{
+		Match: query.Match,
+		Terms: query.Filters,
+		From:  now.Add(-duration),
+		To:    now,
+	}
+
+	if query.GroupBy == "" {
+		count, err := e.esClient.CountLogs(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		return []prometheus.Sample{{Labels: query.Filters, Value: float64(count)}}, nil
+	}
+
+	counts, err := e.esClient.CountLogsByTerm(ctx, filter, query.GroupBy, query.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]prometheus.Sample, 0, len(counts))
+	for term, count := range counts {
+		samples = append(samples, prometheus.Sample{
+			Labels: mergeLabels(query.Filters, map[string]string{query.GroupBy: term}),
+			Value:  float64(count),
+		})
+	}
+
+	return samples, nil
+}
+
+func compare(value float64, operator string, threshold float64) (bool, error) {
+	switch operator {
+	case ">":
+		return value > threshold, nil
+	case ">=":
+		return value >= threshold, nil
+	case "<":
+		return value < threshold, nil
+	case "<=":
+		return value <= threshold, nil
+	case "==":
+		return value == threshold, nil
+	case "!=":
+		return value != threshold, nil
 	default:
-		return 0
+		return false, fmt.Errorf("unknown operator: %s", operator)
+	}
+}
+
+// mergeLabels combines series labels with rule labels. Rule labels win on
+// conflicts so severity and routing labels cannot be overridden by a series.
+func mergeLabels(seriesLabels, ruleLabels map[string]string) map[string]string {
+	labels := make(map[string]string, len(seriesLabels)+len(ruleLabels))
+	for k, v := range seriesLabels {
+		labels[k] = v
+	}
+	for k, v := range ruleLabels {
+		labels[k] = v
 	}
+	return labels
 }
@@ -0,0 +1,308 @@
+package rules
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"awesomeProject6/internal/models"
+)
+
+// History stores alert transitions as JSON lines in a local file. When the
+// file grows past MaxBytes or its oldest entry is older than MaxAge it is
+// rotated to path + ".1", replacing the previous rotation, so at most two
+// files are kept.
+type History struct {
+	path    string
+	options HistoryOptions
+	file    *os.File
+	size    int64
+	started time.Time
+	mutex   sync.Mutex
+}
+
+// HistoryOptions bounds the size of the history. Zero values disable the
+// corresponding limit.
+type HistoryOptions struct {
+	MaxAge   time.Duration
+	MaxBytes int64
+}
+
+// HistoryQuery filters the transitions returned by History.Query. Zero values
+// match everything. Results are returned newest first.
+type HistoryQuery struct {
+	Rule        string
+	Fingerprint string
+	Status      string
+	Labels      map[string]string
+	Since       time.Time
+	Until       time.Time
+	Limit       int
+}
+
+// historyChunkSize is how much of the file Query reads at a time while
+// scanning backwards.
+const historyChunkSize = 64 * 1024
+
+func NewHistory(path string, options HistoryOptions) (*History, error) {
+	h := &History{
+		path:    path,
+		options: options,
+	}
+	if err := h.open(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+func (h *History) Record(transition models.AlertTransition) error {
+	data, err := json.Marshal(transition)
+	if err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.shouldRotate(transition.Timestamp) {
+		if err := h.rotate(); err != nil {
+			return err
+		}
+	}
+	if h.size == 0 {
+		h.started = transition.Timestamp
+	}
+
+	n, err := h.file.Write(append(data, '\n'))
+	h.size += int64(n)
+	return err
+}
+
+// Query returns matching transitions newest first. Files are read backwards
+// and scanning stops once Limit results are found or entries are older than
+// Since or MaxAge. The files are only opened under the lock; scanning reads
+// up to the sizes they had then, so Record is not blocked meanwhile.
+func (h *History) Query(query HistoryQuery) ([]models.AlertTransition, error) {
+	h.mutex.Lock()
+	sections, err := h.snapshot()
+	h.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	defer closeSections(sections)
+
+	since := query.Since
+	if h.options.MaxAge > 0 {
+		if cutoff := time.Now().Add(-h.options.MaxAge); cutoff.After(since) {
+			since = cutoff
+		}
+	}
+
+	var matched []models.AlertTransition
+	visit := func(transition models.AlertTransition) bool {
+		if !since.IsZero() && transition.Timestamp.Before(since) {
+			return false
+		}
+		if query.matches(transition) {
+			matched = append(matched, transition)
+		}
+		return query.Limit <= 0 || len(matched) < query.Limit
+	}
+
+	for _, section := range sections {
+		more, err := scanBackwards(section.file, section.size, visit)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+	}
+
+	return matched, nil
+}
+
+// historySection is a history file opened for reading and the size up to
+// which it holds complete lines.
+type historySection struct {
+	file *os.File
+	size int64
+}
+
+// snapshot opens the current file and the rotation, newest first. An open
+// file keeps its contents if it is rotated while being read. The caller must
+// hold h.mutex so neither is partly written.
+func (h *History) snapshot() ([]historySection, error) {
+	var sections []historySection
+	for _, path := range []string{h.path, h.rotatedPath()} {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			var info os.FileInfo
+			if info, err = file.Stat(); err == nil {
+				sections = append(sections, historySection{file: file, size: info.Size()})
+				continue
+			}
+			file.Close()
+		}
+
+		closeSections(sections)
+		return nil, err
+	}
+	return sections, nil
+}
+
+func closeSections(sections []historySection) {
+	for _, section := range sections {
+		section.file.Close()
+	}
+}
+
+func (h *History) Close() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.file.Close()
+}
+
+func (h *History) open() error {
+	file, err := os.OpenFile(h.path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	// The first entry dates the file for MaxAge rotation.
+	var started time.Time
+	if info.Size() > 0 {
+		line, err := bufio.NewReader(io.NewSectionReader(file, 0, info.Size())).ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			file.Close()
+			return err
+		}
+		var first models.AlertTransition
+		if json.Unmarshal(line, &first) == nil {
+			started = first.Timestamp
+		}
+	}
+
+	h.file = file
+	h.size = info.Size()
+	h.started = started
+	return nil
+}
+
+func (h *History) shouldRotate(now time.Time) bool {
+	if h.size == 0 {
+		return false
+	}
+	if h.options.MaxBytes > 0 && h.size >= h.options.MaxBytes {
+		return true
+	}
+	return h.options.MaxAge > 0 && !h.started.IsZero() && now.Sub(h.started) >= h.options.MaxAge
+}
+
+// rotate moves the current file aside and starts an empty one. The previous
+// rotation only holds entries older than the current file, so it is dropped.
+func (h *History) rotate() error {
+	if err := h.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(h.path, h.rotatedPath()); err != nil {
+		if openErr := h.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+
+	return h.open()
+}
+
+func (h *History) rotatedPath() string {
+	return h.path + ".1"
+}
+
+// scanBackwards calls visit with the transitions in the first size bytes of
+// file from the last line to the first until visit returns false. It reports
+// whether visit asked for more.
+func scanBackwards(file *os.File, size int64, visit func(models.AlertTransition) bool) (bool, error) {
+	// partial holds the end of a line whose beginning lies in the chunk
+	// before it, which has not been read yet.
+	var partial []byte
+	offset := size
+	for offset > 0 {
+		n := int64(historyChunkSize)
+		if n > offset {
+			n = offset
+		}
+		offset -= n
+
+		chunk := make([]byte, n, n+int64(len(partial)))
+		if _, err := file.ReadAt(chunk, offset); err != nil {
+			return false, err
+		}
+		chunk = append(chunk, partial...)
+
+		for {
+			i := bytes.LastIndexByte(chunk, '\n')
+			if i < 0 {
+				break
+			}
+			if !visitLine(chunk[i+1:], visit) {
+				return false, nil
+			}
+			chunk = chunk[:i]
+		}
+		partial = chunk
+	}
+
+	return visitLine(partial, visit), nil
+}
+
+func visitLine(line []byte, visit func(models.AlertTransition) bool) bool {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return true
+	}
+
+	var transition models.AlertTransition
+	if err := json.Unmarshal(line, &transition); err != nil {
+		return true
+	}
+	return visit(transition)
+}
+
+func (q HistoryQuery) matches(transition models.AlertTransition) bool {
+	if q.Rule != "" && transition.Rule != q.Rule {
+		return false
+	}
+	if q.Fingerprint != "" && transition.Fingerprint != q.Fingerprint {
+		return false
+	}
+	if q.Status != "" && transition.From != q.Status && transition.To != q.Status {
+		return false
+	}
+	if !q.Since.IsZero() && transition.Timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && transition.Timestamp.After(q.Until) {
+		return false
+	}
+	for k, v := range q.Labels {
+		if transition.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
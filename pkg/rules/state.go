@@ -0,0 +1,234 @@
+package rules
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+
+	"awesomeProject6/internal/models"
+)
+
+// seriesState remembers the last evaluation of a rule for one series so that
+// status changes can be detected and series which stop returning data can be
+// handled by the rule's no-data policy.
+type seriesState struct {
+	labels   map[string]string
+	value    float64
+	status   string
+	activeAt time.Time
+	baseline *models.Baseline
+}
+
+// RuleStatus describes the outcome of the most recent evaluation of a rule.
+type RuleStatus struct {
+	Name           string         `json:"name"`
+	Type           string         `json:"type"`
+	Health         string         `json:"health"`
+	LastEvaluation time.Time      `json:"last_evaluation"`
+	LastError      string         `json:"last_error,omitempty"`
+	Series         []SeriesStatus `json:"series"`
+}
+
+// SeriesStatus is the last value and alert status of one series of a rule.
+type SeriesStatus struct {
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+	Status string            `json:"status"`
+}
+
+const (
+	HealthUnknown = "unknown"
+	HealthOK      = "ok"
+	HealthError   = "error"
+)
+
+// applyResults updates the stored state of rule with the latest results and
+// returns the alerts to emit together with every status transition. Series
+// present in the previous evaluation but missing from results are resolved
+// according to the rule's no-data policy. A rule that returns no series at
+// all is tracked under the empty label set.
+func (e *Engine) applyResults(rule models.AlertRule, results []seriesResult, now time.Time) ([]models.Alert, []models.AlertTransition, error) {
+	policy := rule.NoData
+	switch policy {
+	case "":
+		policy = models.NoDataOK
+	case models.NoDataOK, models.NoDataAlert, models.NoDataKeepLast:
+	default:
+		return nil, nil, fmt.Errorf("unknown no_data policy: %s", rule.NoData)
+	}
+
+	previous := e.states[rule.Name]
+	current := make(map[string]*seriesState, len(results))
+
+	for _, result := range results {
+		state := &seriesState{
+			labels:   result.labels,
+			value:    result.value,
+			status:   models.AlertStatusInactive,
+			baseline: result.baseline,
+		}
+		if result.triggered {
+			state.status = models.AlertStatusFiring
+		}
+		current[fingerprint(rule.Name, result.labels)] = state
+	}
+
+	missing := previous
+	if len(results) == 0 && len(previous) == 0 {
+		missing = map[string]*seriesState{
+			fingerprint(rule.Name, nil): {labels: map[string]string{}, status: models.AlertStatusInactive},
+		}
+	}
+
+	for fp, state := range missing {
+		if _, ok := current[fp]; ok {
+			continue
+		}
+		// The synthetic rule-level entry is dropped as soon as any series
+		// reports data again.
+		if len(state.labels) == 0 && len(results) > 0 {
+			continue
+		}
+
+		switch policy {
+		case models.NoDataAlert:
+			current[fp] = &seriesState{labels: state.labels, value: state.value, status: models.AlertStatusNoData}
+		case models.NoDataKeepLast:
+			current[fp] = state
+		}
+	}
+
+	var alerts []models.Alert
+	var transitions []models.AlertTransition
+	for fp, state := range current {
+		from := models.AlertStatusInactive
+		if prev, ok := previous[fp]; ok {
+			from = prev.status
+			state.activeAt = prev.activeAt
+		}
+
+		if state.status != from {
+			state.activeAt = now
+			transitions = append(transitions, newTransition(rule, fp, state, from, now))
+		}
+		if state.status != models.AlertStatusInactive {
+			alerts = append(alerts, newAlert(rule, fp, state, now))
+		}
+	}
+
+	for fp, state := range previous {
+		if _, ok := current[fp]; !ok && state.status != models.AlertStatusInactive {
+			resolved := *state
+			resolved.status = models.AlertStatusInactive
+			transitions = append(transitions, newTransition(rule, fp, &resolved, state.status, now))
+		}
+	}
+
+	e.states[rule.Name] = current
+	return alerts, transitions, nil
+}
+
+// ActiveAlerts returns every series that is currently firing or has no data.
+func (e *Engine) ActiveAlerts() []models.Alert {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	var alerts []models.Alert
+	for _, rule := range e.rules {
+		for fp, state := range e.states[rule.Name] {
+			if state.status != models.AlertStatusInactive {
+				alerts = append(alerts, newAlert(rule, fp, state, e.lastEvaluation[rule.Name]))
+			}
+		}
+	}
+
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].ActiveAt.After(alerts[j].ActiveAt)
+	})
+	return alerts
+}
+
+// RuleStatuses returns the evaluation status of every loaded rule.
+func (e *Engine) RuleStatuses() []RuleStatus {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	statuses := make([]RuleStatus, 0, len(e.rules))
+	for _, rule := range e.rules {
+		status := RuleStatus{
+			Name:           rule.Name,
+			Type:           rule.Type,
+			Health:         HealthUnknown,
+			LastEvaluation: e.lastEvaluation[rule.Name],
+			Series:         make([]SeriesStatus, 0, len(e.states[rule.Name])),
+		}
+		if status.Type == "" {
+			status.Type = models.RuleTypeMetric
+		}
+		if !status.LastEvaluation.IsZero() {
+			status.Health = HealthOK
+		}
+		if err, ok := e.lastError[rule.Name]; ok {
+			status.Health = HealthError
+			status.LastError = err
+		}
+
+		for _, state := range e.states[rule.Name] {
+			status.Series = append(status.Series, SeriesStatus{
+				Labels: state.labels,
+				Value:  state.value,
+				Status: state.status,
+			})
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+func newAlert(rule models.AlertRule, fp string, state *seriesState, now time.Time) models.Alert {
+	return models.Alert{
+		Rule:        rule,
+		Fingerprint: fp,
+		Value:       state.value,
+		Timestamp:   now,
+		ActiveAt:    state.activeAt,
+		Status:      state.status,
+		Labels:      mergeLabels(state.labels, rule.Labels),
+		Baseline:    state.baseline,
+	}
+}
+
+func newTransition(rule models.AlertRule, fp string, state *seriesState, from string, now time.Time) models.AlertTransition {
+	return models.AlertTransition{
+		Rule:        rule.Name,
+		Fingerprint: fp,
+		Labels:      mergeLabels(state.labels, rule.Labels),
+		From:        from,
+		To:          state.status,
+		Value:       state.value,
+		Timestamp:   now,
+	}
+}
+
+// fingerprint identifies a series of a rule independently of map ordering.
+func fingerprint(ruleName string, labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	h := fnv.New64a()
+	h.Write([]byte(ruleName))
+	h.Write([]byte{0xff})
+	for _, k := range names {
+		h.Write([]byte(k))
+		h.Write([]byte{0xff})
+		h.Write([]byte(labels[k]))
+		h.Write([]byte{0xff})
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
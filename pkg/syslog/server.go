@@ -0,0 +1,364 @@
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"awesomeProject6/pkg/kafka"
+	"awesomeProject6/pkg/parser"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	syslogMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_ingestion_syslog_messages_total",
+		Help: "Syslog messages received.",
+	}, []string{"transport"})
+
+	syslogFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_ingestion_syslog_parse_failures_total",
+		Help: "Syslog messages dropped because they could not be parsed.",
+	}, []string{"transport"})
+)
+
+// Config selects the addresses a Server listens on; an empty address
+// disables that transport. Service is the service of messages without an
+// app-name ("syslog" by default). Longer messages than MaxMessageBytes (64
+// KiB by default) close a stream connection and are truncated over UDP.
+type Config struct {
+	UDPAddr         string
+	TCPAddr         string
+	TLSAddr         string
+	TLS             TLSConfig
+	Service         string
+	MaxMessageBytes int
+}
+
+// TLSConfig holds the server certificate for the TLS listener. With
+// ClientCAFile set, clients must present a certificate signed by it.
+type TLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+// Server receives RFC 5424 and RFC 3164 syslog messages over UDP, TCP and
+// TLS and sends them into the ingestion pipeline. Stream transports accept
+// both octet-counted and newline-delimited framing (RFC 6587).
+type Server struct {
+	config    Config
+	out       chan<- kafka.LogMessage
+	parsers   *parser.Registry
+	tlsConfig *tls.Config
+	logger    *logrus.Logger
+
+	packetConn net.PacketConn
+	listeners  map[string]net.Listener
+	conns      map[net.Conn]bool
+	mutex      sync.Mutex
+}
+
+// NewServer creates a server sending the entries it receives to out. The
+// registry supplies the defaults for what a message leaves out.
+func NewServer(config Config, out chan<- kafka.LogMessage, parsers *parser.Registry) (*Server, error) {
+	if config.UDPAddr == "" && config.TCPAddr == "" && config.TLSAddr == "" {
+		return nil, fmt.Errorf("syslog needs at least one of udp_addr, tcp_addr and tls_addr")
+	}
+	if config.Service == "" {
+		config.Service = "syslog"
+	}
+	if config.MaxMessageBytes <= 0 {
+		config.MaxMessageBytes = 64 * 1024
+	}
+
+	s := &Server{
+		config:    config,
+		out:       out,
+		parsers:   parsers,
+		logger:    logrus.New(),
+		listeners: make(map[string]net.Listener),
+		conns:     make(map[net.Conn]bool),
+	}
+
+	if config.TLSAddr != "" {
+		tlsConfig, err := config.TLS.build()
+		if err != nil {
+			return nil, err
+		}
+		s.tlsConfig = tlsConfig
+	}
+
+	return s, nil
+}
+
+func (t TLSConfig) build() (*tls.Config, error) {
+	if t.CertFile == "" || t.KeyFile == "" {
+		return nil, fmt.Errorf("syslog TLS requires cert_file and key_file")
+	}
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load syslog certificate: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if t.ClientCAFile != "" {
+		pem, err := os.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", t.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// Listen opens the configured listeners.
+func (s *Server) Listen() error {
+	var err error
+	if s.config.UDPAddr != "" {
+		if s.packetConn, err = net.ListenPacket("udp", s.config.UDPAddr); err != nil {
+			s.closeListeners()
+			return fmt.Errorf("failed to listen on udp %s: %v", s.config.UDPAddr, err)
+		}
+	}
+	if s.config.TCPAddr != "" {
+		if s.listeners["tcp"], err = net.Listen("tcp", s.config.TCPAddr); err != nil {
+			delete(s.listeners, "tcp")
+			s.closeListeners()
+			return fmt.Errorf("failed to listen on tcp %s: %v", s.config.TCPAddr, err)
+		}
+	}
+	if s.config.TLSAddr != "" {
+		if s.listeners["tls"], err = tls.Listen("tcp", s.config.TLSAddr, s.tlsConfig); err != nil {
+			delete(s.listeners, "tls")
+			s.closeListeners()
+			return fmt.Errorf("failed to listen on tls %s: %v", s.config.TLSAddr, err)
+		}
+	}
+	return nil
+}
+
+// Serve receives messages on the listeners opened by Listen until ctx is
+// cancelled, then closes them along with every open connection.
+func (s *Server) Serve(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	if s.packetConn != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveUDP(ctx)
+		}()
+	}
+	for transport, listener := range s.listeners {
+		wg.Add(1)
+		go func(transport string, listener net.Listener) {
+			defer wg.Done()
+			s.serveStream(ctx, transport, listener, &wg)
+		}(transport, listener)
+	}
+
+	<-ctx.Done()
+	s.closeListeners()
+
+	s.mutex.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mutex.Unlock()
+
+	wg.Wait()
+}
+
+func (s *Server) closeListeners() {
+	if s.packetConn != nil {
+		s.packetConn.Close()
+	}
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
+}
+
+// serveUDP handles datagrams, each of which holds one message.
+func (s *Server) serveUDP(ctx context.Context) {
+	buf := make([]byte, s.config.MaxMessageBytes)
+	for {
+		n, addr, err := s.packetConn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.Errorf("Failed to read syslog datagram: %v", err)
+			}
+			return
+		}
+
+		frame := make([]byte, n)
+		copy(frame, buf[:n])
+		if !s.handle(ctx, "udp", frame, addr) {
+			return
+		}
+	}
+}
+
+func (s *Server) serveStream(ctx context.Context, transport string, listener net.Listener, wg *sync.WaitGroup) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.Errorf("Failed to accept syslog %s connection: %v", transport, err)
+			}
+			return
+		}
+
+		s.mutex.Lock()
+		if ctx.Err() != nil {
+			s.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.mutex.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveConn(ctx, transport, conn)
+		}()
+	}
+}
+
+func (s *Server) serveConn(ctx context.Context, transport string, conn net.Conn) {
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		frame, err := readFrame(reader, s.config.MaxMessageBytes)
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				s.logger.Warnf("Closing syslog %s connection from %s: %v", transport, conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if len(bytes.TrimSpace(frame)) == 0 {
+			continue
+		}
+		if !s.handle(ctx, transport, frame, conn.RemoteAddr()) {
+			return
+		}
+	}
+}
+
+// handle parses one message and sends it on, waiting while the pipeline is
+// busy. Messages without a hostname get the sender's address. It returns
+// false once ctx is cancelled.
+func (s *Server) handle(ctx context.Context, transport string, frame []byte, peer net.Addr) bool {
+	syslogMessages.WithLabelValues(transport).Inc()
+
+	entry, err := parser.ParseSyslog(frame)
+	if err != nil {
+		syslogFailures.WithLabelValues(transport).Inc()
+		s.logger.Debugf("Dropping invalid syslog message from %s: %v", peer, err)
+		return true
+	}
+
+	if entry.Host == "" {
+		if host, _, err := net.SplitHostPort(peer.String()); err == nil {
+			entry.Host = host
+		}
+	}
+	s.parsers.ApplyDefaults(&entry, s.config.Service, time.Now())
+
+	select {
+	case s.out <- kafka.NewLogMessage(entry, nil):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+var errFrameTooLarge = errors.New("syslog message too large")
+
+// readFrame reads one message from a stream. A message starting with a
+// digit is octet-counted ("<length> <message>"); any other message ends at
+// a newline.
+func readFrame(r *bufio.Reader, maxBytes int) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] >= '0' && first[0] <= '9' {
+		var digits []byte
+		for {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if b == ' ' {
+				break
+			}
+			if b < '0' || b > '9' || len(digits) >= 10 {
+				return nil, fmt.Errorf("invalid octet count")
+			}
+			digits = append(digits, b)
+		}
+
+		length, err := strconv.Atoi(string(digits))
+		if err != nil {
+			return nil, fmt.Errorf("invalid octet count")
+		}
+		if length > maxBytes {
+			return nil, errFrameTooLarge
+		}
+
+		frame := make([]byte, length)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return nil, err
+		}
+		return frame, nil
+	}
+
+	var frame []byte
+	for {
+		line, err := r.ReadSlice('\n')
+		frame = append(frame, line...)
+		if len(frame) > maxBytes {
+			return nil, errFrameTooLarge
+		}
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF && len(frame) > 0:
+			return frame, nil
+		case err != nil:
+			return nil, err
+		}
+		return frame, nil
+	}
+}
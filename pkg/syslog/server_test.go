@@ -0,0 +1,234 @@
+package syslog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"awesomeProject6/pkg/kafka"
+	"awesomeProject6/pkg/parser"
+)
+
+const testMaxMessageBytes = 64
+
+// received is the part of a parsed entry the tests compare.
+type received struct {
+	host    string
+	service string
+	message string
+}
+
+// octetCounted frames message with its length, as in RFC 6587.
+func octetCounted(message string) string {
+	return fmt.Sprintf("%d %s", len(message), message)
+}
+
+// startServer serves the given transports on loopback ports with a
+// MaxMessageBytes of testMaxMessageBytes until the test ends.
+func startServer(t *testing.T, config Config) (*Server, <-chan kafka.LogMessage) {
+	t.Helper()
+
+	parsers, err := parser.NewRegistry("", parser.Defaults{})
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+
+	config.MaxMessageBytes = testMaxMessageBytes
+	out := make(chan kafka.LogMessage, 100)
+	s, err := NewServer(config, out, parsers)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	s.logger.SetOutput(io.Discard)
+	if err := s.Listen(); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Serve(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return s, out
+}
+
+// drain returns the entries waiting in out.
+func drain(out <-chan kafka.LogMessage) []received {
+	var got []received
+	for {
+		select {
+		case msg := <-out:
+			got = append(got, received{msg.Entry.Host, msg.Entry.Service, msg.Entry.Message})
+		default:
+			return got
+		}
+	}
+}
+
+func TestServerStreamFraming(t *testing.T) {
+	long := "<14>1 - web01 app - - - " + strings.Repeat("x", testMaxMessageBytes)
+
+	tests := []struct {
+		name  string
+		input string
+		want  []received
+		// closed is set when the server closes the connection itself
+		// instead of reading the input to its end.
+		closed bool
+	}{
+		{
+			name:  "newline framing",
+			input: "<14>1 - web01 app - - - one\n<14>Aug 16 10:20:30 web02 sshd: two\r\n",
+			want: []received{
+				{"web01", "app", "one"},
+				{"web02", "sshd", "two"},
+			},
+		},
+		{
+			name:  "octet counting",
+			input: octetCounted("<14>1 - web01 app - - - one\nstill one") + octetCounted("<14>1 - web01 app - - - two"),
+			want: []received{
+				{"web01", "app", "one\nstill one"},
+				{"web01", "app", "two"},
+			},
+		},
+		{
+			name:  "mixed framing",
+			input: octetCounted("<14>1 - web01 app - - - one") + "<14>1 - web01 app - - - two\n" + octetCounted("<14>1 - web01 app - - - three"),
+			want: []received{
+				{"web01", "app", "one"},
+				{"web01", "app", "two"},
+				{"web01", "app", "three"},
+			},
+		},
+		{
+			name:  "unterminated last line",
+			input: "<14>1 - web01 app - - - one\n<14>1 - web01 app - - - two",
+			want: []received{
+				{"web01", "app", "one"},
+				{"web01", "app", "two"},
+			},
+		},
+		{
+			name:  "blank lines and invalid messages are skipped",
+			input: "\n  \n<14>1 - web01 app - - - one\nnot syslog\n<14>1 - web01 app - - - two\n",
+			want: []received{
+				{"web01", "app", "one"},
+				{"web01", "app", "two"},
+			},
+		},
+		{
+			name:  "defaults for missing header fields",
+			input: "<14>1 - - - - - - bare\n",
+			want:  []received{{"127.0.0.1", "syslog", "bare"}},
+		},
+		{
+			name:   "oversize octet-counted frame",
+			input:  octetCounted("<14>1 - web01 app - - - one") + octetCounted(long) + octetCounted("<14>1 - web01 app - - - two"),
+			want:   []received{{"web01", "app", "one"}},
+			closed: true,
+		},
+		{
+			name:   "oversize line",
+			input:  "<14>1 - web01 app - - - one\n" + long + "\n<14>1 - web01 app - - - two\n",
+			want:   []received{{"web01", "app", "one"}},
+			closed: true,
+		},
+		{
+			name:   "invalid octet count",
+			input:  "<14>1 - web01 app - - - one\n12x <14>1 - - - - - - two",
+			want:   []received{{"web01", "app", "one"}},
+			closed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, out := startServer(t, Config{TCPAddr: "127.0.0.1:0"})
+
+			conn, err := net.Dial("tcp", s.listeners["tcp"].Addr().String())
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			defer conn.Close()
+
+			if _, err := io.WriteString(conn, tt.input); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			if !tt.closed {
+				conn.(*net.TCPConn).CloseWrite()
+			}
+
+			// The server handles every frame before reading the next, so
+			// once it has closed the connection everything it accepted is
+			// in out.
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			if _, err := io.ReadAll(conn); err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					t.Fatal("server did not close the connection")
+				}
+			}
+
+			if got := drain(out); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("received %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerUDP(t *testing.T) {
+	s, out := startServer(t, Config{UDPAddr: "127.0.0.1:0"})
+
+	conn, err := net.Dial("udp", s.packetConn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	datagrams := []string{
+		"<14>1 - web01 app - - - one",
+		// Each datagram is one message, newlines included.
+		"<14>1 - web01 app - - - two\nlines\n",
+		"not syslog",
+		"<14>1 - - - - - - bare",
+		// Longer datagrams are cut at MaxMessageBytes.
+		"<14>1 - web01 app - - - " + strings.Repeat("x", testMaxMessageBytes),
+		"<14>1 - web01 app - - - last",
+	}
+	for _, datagram := range datagrams {
+		if _, err := io.WriteString(conn, datagram); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	want := []received{
+		{"web01", "app", "one"},
+		{"web01", "app", "two\nlines"},
+		{"127.0.0.1", "syslog", "bare"},
+		{"web01", "app", strings.Repeat("x", testMaxMessageBytes-len("<14>1 - web01 app - - - "))},
+		{"web01", "app", "last"},
+	}
+	var got []received
+	timeout := time.After(2 * time.Second)
+	for len(got) < len(want) {
+		select {
+		case msg := <-out:
+			got = append(got, received{msg.Entry.Host, msg.Entry.Service, msg.Entry.Message})
+		case <-timeout:
+			t.Fatalf("received %v before timing out, want %v", got, want)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+}